@@ -0,0 +1,51 @@
+// Команда keygen создаёт пару ключей RSA для шифрования данных агента:
+// закрытый ключ передаётся серверу, открытый — агентам (-crypto-key).
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/encryption"
+)
+
+const (
+	defaultBits       = 4096
+	defaultPrivateKey = "private.pem"
+	defaultPublicKey  = "public.pem"
+)
+
+func main() {
+	bits := flag.Int("bits", defaultBits, "RSA key size in bits")
+	privatePath := flag.String("private", defaultPrivateKey, "Output path for the private key (PEM)")
+	publicPath := flag.String("public", defaultPublicKey, "Output path for the public key (PEM)")
+	flag.Parse()
+
+	if *bits < 2048 {
+		log.Fatalf("key size must be at least 2048 bits, got %d", *bits)
+	}
+
+	priv, err := encryption.GenerateKey(*bits)
+	if err != nil {
+		log.Fatalf("failed to generate key: %v", err)
+	}
+
+	privPEM, err := encryption.EncodePrivateKey(priv)
+	if err != nil {
+		log.Fatalf("failed to encode private key: %v", err)
+	}
+	pubPEM, err := encryption.EncodePublicKey(&priv.PublicKey)
+	if err != nil {
+		log.Fatalf("failed to encode public key: %v", err)
+	}
+
+	if err := os.WriteFile(*privatePath, privPEM, 0o600); err != nil {
+		log.Fatalf("failed to write private key: %v", err)
+	}
+	if err := os.WriteFile(*publicPath, pubPEM, 0o644); err != nil {
+		log.Fatalf("failed to write public key: %v", err)
+	}
+
+	log.Printf("Wrote private key to %s and public key to %s", *privatePath, *publicPath)
+}
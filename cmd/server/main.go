@@ -1,172 +1,206 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"io"
 	"log"
+	"net"
 	"os"
-	"strconv"
-	"strings"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/LemuriiL/MetricsAllerts/internal/alerting"
+	"github.com/LemuriiL/MetricsAllerts/internal/config"
+	"github.com/LemuriiL/MetricsAllerts/internal/encryption"
 	"github.com/LemuriiL/MetricsAllerts/internal/server"
 	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/sirupsen/logrus"
 )
 
-const (
-	defaultAddr          = "localhost:8080"
-	defaultStoreInterval = 300
-	defaultFilePath      = "metrics-db.json"
-	defaultRestore       = true
-)
-
-type stringFlag struct {
-	val   string
-	isSet bool
-}
-
-func (s *stringFlag) String() string { return s.val }
-func (s *stringFlag) Set(v string) error {
-	s.val = v
-	s.isSet = true
-	return nil
-}
-
-type intFlag struct {
-	val   int
-	isSet bool
-}
-
-func (i *intFlag) String() string { return strconv.Itoa(i.val) }
-func (i *intFlag) Set(v string) error {
-	n, err := strconv.Atoi(v)
+func main() {
+	cfg, err := config.LoadServer(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
-	i.val = n
-	i.isSet = true
-	return nil
-}
+	// Уровень уже проверен в config.Server.Validate.
+	level, _ := logrus.ParseLevel(cfg.LogLevel)
+	logrus.SetLevel(level)
 
-type boolFlag struct {
-	val   bool
-	isSet bool
-}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-func (b *boolFlag) String() string {
-	if b.val {
-		return "true"
-	}
-	return "false"
-}
-func (b *boolFlag) Set(v string) error {
-	x, err := strconv.ParseBool(v)
+	var (
+		intervals = make(chan time.Duration)
+		wg        sync.WaitGroup
+	)
+	base, err := openStorage(ctx, cfg)
 	if err != nil {
-		return err
-	}
-	b.val = x
-	b.isSet = true
-	return nil
-}
-
-func envString(key string) (string, bool) {
-	v, ok := os.LookupEnv(key)
-	if !ok {
-		return "", false
+		log.Fatal(err)
 	}
-	v = strings.TrimSpace(v)
-	if v == "" {
-		return "", false
+	if cfg.ImportFile != "" {
+		// Validate пропускает import_file только вместе с bolt-хранилищем.
+		n, err := base.(*storage.BoltStorage).ImportFile(cfg.ImportFile)
+		switch {
+		case errors.Is(err, storage.ErrNotEmpty):
+			log.Printf("Storage already has metrics, skipping import of %s", cfg.ImportFile)
+		case err != nil:
+			log.Fatal(err)
+		default:
+			log.Printf("Imported %d metrics from %s", n, cfg.ImportFile)
+		}
 	}
-	return v, true
-}
-
-func envInt(key string) (int, bool) {
-	v, ok := envString(key)
-	if !ok {
-		return 0, false
+	fileStore, _ := base.(*storage.FileStorage)
+	if fileStore != nil {
+		fileStore.SetSyncWrite(cfg.StoreInterval.Duration == 0)
+		fileStore.SetBackups(cfg.SnapshotBackups)
+		fileStore.SetRecover(cfg.RestoreRecover)
+	}
+
+	store := storage.NewTimeSeriesStorage(base, storage.Retention{
+		Raw:        cfg.SeriesRawRetention.Duration,
+		Resolution: cfg.SeriesResolution.Duration,
+		Total:      cfg.SeriesRetention.Duration,
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		store.Run(ctx, cfg.SeriesResolution.Duration)
+	}()
+
+	opts := []server.Option{server.WithKey(cfg.Key)}
+	if cfg.CryptoKey != "" {
+		priv, err := encryption.LoadPrivateKey(cfg.CryptoKey)
+		if err != nil {
+			log.Fatalf("failed to load crypto key: %v", err)
+		}
+		opts = append(opts, server.WithCryptoKey(priv))
 	}
-	n, err := strconv.Atoi(v)
-	if err != nil {
-		return 0, false
+	if cfg.TrustedSubnet != "" {
+		// Подсеть уже проверена в config.Server.Validate.
+		_, subnet, _ := net.ParseCIDR(cfg.TrustedSubnet)
+		opts = append(opts, server.WithTrustedSubnet(subnet))
 	}
-	return n, true
-}
 
-func envBool(key string) (bool, bool) {
-	v, ok := envString(key)
-	if !ok {
-		return false, false
-	}
-	x, err := strconv.ParseBool(v)
+	// Движок запускается и без правил, чтобы их можно было добавить
+	// перезагрузкой конфигурации.
+	rules, err := loadRules(cfg.AlertRules)
 	if err != nil {
-		return false, false
+		log.Fatal(err)
 	}
-	return x, true
-}
-
-func main() {
-	addr := defaultAddr
-	storeInterval := defaultStoreInterval
-	filePath := defaultFilePath
-	restore := defaultRestore
-
-	aFlag := &stringFlag{val: defaultAddr}
-	iFlag := &intFlag{val: defaultStoreInterval}
-	fFlag := &stringFlag{val: defaultFilePath}
-	rFlag := &boolFlag{val: defaultRestore}
-
-	flag.Var(aFlag, "a", "HTTP server address")
-	flag.Var(iFlag, "i", "Store interval in seconds")
-	flag.Var(fFlag, "f", "File storage path")
-	flag.Var(rFlag, "r", "Restore from file on start")
+	engine := alerting.NewEngine(store, rules, nil)
+	if len(cfg.AlertWebhooks) > 0 {
+		engine.SetNotifier(alerting.NewNotifier(store, cfg.AlertWebhooks, cfg.AlertRepeatInterval.Duration, nil))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		engine.Run(ctx, cfg.AlertInterval.Duration)
+	}()
+	opts = append(opts, server.WithAlerts(engine))
+	if cfg.AlertRules != "" {
+		log.Printf("Loaded %d alert rules from %s", len(rules), cfg.AlertRules)
+	}
+
+	r := &reloader{
+		cfg: cfg,
+		load: func() (config.Server, error) {
+			return config.LoadServer(os.Args[1:], os.LookupEnv)
+		},
+		fileStore: fileStore,
+		intervals: intervals,
+		engine:    engine,
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				log.Printf("Reloading configuration")
+				r.reload(ctx)
+			}
+		}
+	}()
 
-	flag.Parse()
+	srv := server.New(store, opts...)
 
-	if v, ok := envString("ADDRESS"); ok {
-		addr = v
-	} else if aFlag.isSet {
-		addr = aFlag.val
+	// Метрики восстанавливаются, когда сервер уже слушает порт: до конца
+	// восстановления /readyz отвечает 503, а запись метрик отклоняется.
+	var restoreErr error
+	if fileStore != nil {
+		srv.SetReady(false)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cfg.Restore {
+				if restoreErr = fileStore.Restore(); restoreErr != nil {
+					stop()
+					return
+				}
+			}
+			srv.SetReady(true)
+			runSaver(ctx, fileStore, cfg.StoreInterval.Duration, intervals)
+		}()
 	}
 
-	if v, ok := envInt("STORE_INTERVAL"); ok {
-		storeInterval = v
-	} else if iFlag.isSet {
-		storeInterval = iFlag.val
+	var grpcErr error
+	if cfg.GRPCAddress != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("Starting gRPC server on %s", cfg.GRPCAddress)
+			if grpcErr = srv.RunGRPC(ctx, cfg.GRPCAddress); grpcErr != nil {
+				stop()
+			}
+		}()
 	}
 
-	if v, ok := envString("FILE_STORAGE_PATH"); ok {
-		filePath = v
-	} else if fFlag.isSet {
-		filePath = fFlag.val
+	log.Printf("Starting server on %s", cfg.Address)
+	runErr := srv.Run(ctx, cfg.Address)
+	stop()
+	wg.Wait()
+	if runErr == nil {
+		runErr = grpcErr
 	}
-
-	if v, ok := envBool("RESTORE"); ok {
-		restore = v
-	} else if rFlag.isSet {
-		restore = rFlag.val
+	if restoreErr != nil {
+		log.Fatalf("failed to restore metrics: %v", restoreErr)
 	}
 
-	store := storage.NewFileStorage(filePath, storeInterval == 0)
-
-	if restore {
-		if err := store.Restore(); err != nil {
-			log.Fatal(err)
+	if fileStore != nil {
+		if err := fileStore.Save(); err != nil {
+			log.Printf("failed to save metrics on shutdown: %v", err)
 		}
 	}
-
-	if storeInterval > 0 {
-		ticker := time.NewTicker(time.Duration(storeInterval) * time.Second)
-		go func() {
-			for range ticker.C {
-				_ = store.Save()
-			}
-		}()
+	if c, ok := base.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("failed to close storage: %v", err)
+		}
 	}
 
-	srv := server.New(store)
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
+	log.Printf("Server stopped")
+}
 
-	log.Printf("Starting server on %s", addr)
-	if err := srv.Run(addr); err != nil {
-		log.Fatal(err)
+// openStorage открывает хранилище из настроек. Путь file_storage_path
+// передаётся как есть: в нём допустимы пробелы и двоеточия.
+func openStorage(ctx context.Context, cfg config.Server) (storage.Storage, error) {
+	dsn := cfg.StorageDSN()
+	if dsn == "" {
+		return storage.NewFileStorage(cfg.FileStoragePath, false), nil
 	}
+	return storage.Open(ctx, dsn)
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/config"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenStorageFilePath(t *testing.T) {
+	for _, name := range []string{"my dir/m.json", "a:b.json", "50%.json"} {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.DefaultServer()
+			cfg.FileStoragePath = filepath.Join(t.TempDir(), name)
+
+			s, err := openStorage(context.Background(), cfg)
+			require.NoError(t, err)
+			fs, ok := s.(*storage.FileStorage)
+			require.True(t, ok)
+			assert.Equal(t, cfg.FileStoragePath, fs.Path())
+		})
+	}
+
+	cfg := config.DefaultServer()
+	cfg.Storage = "mem://"
+	s, err := openStorage(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.IsType(t, &storage.MemStorage{}, s)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/alerting"
+	"github.com/LemuriiL/MetricsAllerts/internal/config"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// reloader применяет к работающему серверу настройки, перечитанные
+// по SIGHUP. На лету меняются интервал и путь сохранения файла, число
+// резервных копий снимка, уровень логирования и правила алертинга;
+// остальные настройки требуют перезапуска.
+type reloader struct {
+	cfg       config.Server
+	load      func() (config.Server, error)
+	fileStore *storage.FileStorage
+	intervals chan<- time.Duration
+	engine    *alerting.Engine
+}
+
+// restartOnly перечисляет настройки, которые нельзя изменить без
+// перезапуска. Значения только сравниваются и в лог не попадают.
+func restartOnly(c config.Server) map[string]string {
+	return map[string]string{
+		"address":               c.Address,
+		"grpc_address":          c.GRPCAddress,
+		"database_dsn":          c.DatabaseDSN,
+		"storage":               c.Storage,
+		"import_file":           c.ImportFile,
+		"restore_recover":       fmt.Sprint(c.RestoreRecover),
+		"key":                   c.Key,
+		"crypto_key":            c.CryptoKey,
+		"trusted_subnet":        c.TrustedSubnet,
+		"alert_interval":        c.AlertInterval.String(),
+		"alert_webhooks":        fmt.Sprint(c.AlertWebhooks),
+		"alert_repeat_interval": c.AlertRepeatInterval.String(),
+		"series_raw_retention":  c.SeriesRawRetention.String(),
+		"series_resolution":     c.SeriesResolution.String(),
+		"series_retention":      c.SeriesRetention.String(),
+	}
+}
+
+func (r *reloader) reload(ctx context.Context) {
+	next, err := r.load()
+	if err != nil {
+		log.Printf("reload: %v; keeping current configuration", err)
+		return
+	}
+
+	cur, upd := restartOnly(r.cfg), restartOnly(next)
+	names := make([]string, 0, len(cur))
+	for name := range cur {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if cur[name] != upd[name] {
+			log.Printf("reload: %s cannot be changed without restart, ignoring", name)
+		}
+	}
+
+	if next.LogLevel != r.cfg.LogLevel {
+		// Уровень уже проверен в config.Server.Validate.
+		level, _ := logrus.ParseLevel(next.LogLevel)
+		logrus.SetLevel(level)
+		log.Printf("reload: log level set to %s", level)
+		r.cfg.LogLevel = next.LogLevel
+	}
+
+	if r.fileStore != nil {
+		// При явном storage путь задан адресом и file_storage_path не используется.
+		if r.cfg.Storage == "" && next.FileStoragePath != r.cfg.FileStoragePath {
+			if err := r.fileStore.SetPath(next.FileStoragePath); err != nil {
+				log.Printf("reload: failed to switch storage file to %s: %v", next.FileStoragePath, err)
+			} else {
+				log.Printf("reload: storage file moved to %s", next.FileStoragePath)
+				r.cfg.FileStoragePath = next.FileStoragePath
+			}
+		}
+		if next.SnapshotBackups != r.cfg.SnapshotBackups {
+			r.fileStore.SetBackups(next.SnapshotBackups)
+			log.Printf("reload: keeping %d snapshot backups", next.SnapshotBackups)
+			r.cfg.SnapshotBackups = next.SnapshotBackups
+		}
+		if next.StoreInterval != r.cfg.StoreInterval {
+			r.fileStore.SetSyncWrite(next.StoreInterval.Duration == 0)
+			select {
+			case r.intervals <- next.StoreInterval.Duration:
+			case <-ctx.Done():
+				return
+			}
+			log.Printf("reload: store interval set to %s", next.StoreInterval)
+			r.cfg.StoreInterval = next.StoreInterval
+		}
+	}
+
+	// Файл правил перечитывается всегда: он мог измениться и без смены пути.
+	rules, err := loadRules(next.AlertRules)
+	if err != nil {
+		log.Printf("reload: %v; keeping current alert rules", err)
+		return
+	}
+	r.engine.SetRules(rules)
+	r.cfg.AlertRules = next.AlertRules
+	log.Printf("reload: %d alert rules active", len(rules))
+}
+
+func loadRules(path string) ([]alerting.Rule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return alerting.LoadRules(path)
+}
+
+// runSaver периодически сбрасывает журнал fileStore на диск. Новый
+// интервал приходит через intervals; нулевой интервал останавливает
+// периодический сброс — тогда хранилище делает fsync при каждом изменении.
+func runSaver(ctx context.Context, fileStore *storage.FileStorage, interval time.Duration, intervals <-chan time.Duration) {
+	var (
+		ticker *time.Ticker
+		tick   <-chan time.Time
+	)
+	reset := func(d time.Duration) {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		if d > 0 {
+			ticker = time.NewTicker(d)
+			tick = ticker.C
+		}
+	}
+	reset(interval)
+	defer reset(0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-intervals:
+			reset(d)
+		case <-tick:
+			if err := fileStore.Sync(); err != nil {
+				log.Printf("failed to sync metrics: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/alerting"
+	"github.com/LemuriiL/MetricsAllerts/internal/config"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLog перенаправляет стандартный лог в буфер до конца теста.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func writeRules(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(body), 0o644))
+	return path
+}
+
+func newTestReloader(t *testing.T, cfg config.Server, next config.Server) *reloader {
+	rules, err := loadRules(cfg.AlertRules)
+	require.NoError(t, err)
+	store := storage.NewMemStorage()
+	return &reloader{
+		cfg:    cfg,
+		load:   func() (config.Server, error) { return next, nil },
+		engine: alerting.NewEngine(store, rules, nil),
+	}
+}
+
+func TestReloadIgnoresRestartOnlyOptions(t *testing.T) {
+	logs := captureLog(t)
+	level := logrus.GetLevel()
+	t.Cleanup(func() { logrus.SetLevel(level) })
+	cfg := config.DefaultServer()
+	next := cfg
+	next.Address = "localhost:9090"
+	next.Key = "new-secret"
+	next.LogLevel = "debug"
+
+	r := newTestReloader(t, cfg, next)
+	r.reload(context.Background())
+
+	assert.Equal(t, cfg.Address, r.cfg.Address)
+	assert.Equal(t, cfg.Key, r.cfg.Key)
+	assert.Equal(t, "debug", r.cfg.LogLevel, "live options are still applied")
+	assert.Contains(t, logs.String(), "address cannot be changed without restart")
+	assert.Contains(t, logs.String(), "key cannot be changed without restart")
+	assert.NotContains(t, logs.String(), "new-secret", "values are not logged")
+}
+
+func TestReloadStoreIntervalReachesSaver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	fileStore := storage.NewFileStorage(path, false)
+	fileStore.SetGauge("g", 1)
+	fileStore.SetGauge("g", 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	intervals := make(chan time.Duration)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runSaver(ctx, fileStore, 0, intervals)
+	}()
+
+	cfg := config.DefaultServer()
+	cfg.StoreInterval = config.Seconds(0)
+	next := cfg
+	next.StoreInterval = config.Duration{Duration: 5 * time.Millisecond}
+
+	r := newTestReloader(t, cfg, next)
+	r.fileStore = fileStore
+	r.intervals = intervals
+	r.reload(ctx)
+	assert.Equal(t, next.StoreInterval, r.cfg.StoreInterval)
+
+	assert.Eventually(t, func() bool {
+		info, err := os.Stat(path + ".wal")
+		return err == nil && info.Size() > 0
+	}, time.Second, time.Millisecond, "saver syncs the log with the new interval")
+
+	cancel()
+	<-done
+}
+
+func TestReloadKeepsRulesOnError(t *testing.T) {
+	logs := captureLog(t)
+	cfg := config.DefaultServer()
+	cfg.AlertRules = writeRules(t, "rules:\n  - name: HighHeap\n    expr: gauge HeapAlloc > 500MB\n")
+	next := cfg
+	next.AlertRules = writeRules(t, "rules:\n  - name: Broken\n    expr: gauge HeapAlloc >\n")
+
+	r := newTestReloader(t, cfg, next)
+	r.reload(context.Background())
+
+	rules := r.engine.Rules()
+	require.Len(t, rules, 1)
+	assert.Equal(t, "HighHeap", rules[0].Name)
+	assert.Equal(t, cfg.AlertRules, r.cfg.AlertRules)
+	assert.Contains(t, logs.String(), "keeping current alert rules")
+
+	next.AlertRules = writeRules(t, "rules:\n  - name: LowHeap\n    expr: gauge HeapAlloc < 1MB\n")
+	r.load = func() (config.Server, error) { return next, nil }
+	r.reload(context.Background())
+	rules = r.engine.Rules()
+	require.Len(t, rules, 1)
+	assert.Equal(t, "LowHeap", rules[0].Name)
+}
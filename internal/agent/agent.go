@@ -1,66 +1,290 @@
 package agent
 
 import (
+	"context"
+	"crypto/rsa"
+	"errors"
 	"log"
+	"os"
+	"runtime"
+	"sync"
 	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+const (
+	defaultRateLimit   = 1
+	finalReportTimeout = 5 * time.Second
 )
 
 type Agent struct {
-	collector    *Collector
-	sender       *Sender
-	pollTicker   *time.Ticker
-	reportTicker *time.Ticker
-	stopCh       chan struct{}
+	collector      *Collector
+	system         *SystemCollector
+	sender         *Sender
+	pollInterval   time.Duration
+	reportInterval time.Duration
+	rateLimit      int
+	labels         map[string]string
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+type Option func(*Agent)
+
+// WithKey включает HMAC-SHA256 подпись отправляемых данных.
+func WithKey(key string) Option {
+	return func(a *Agent) {
+		a.sender.key = key
+	}
+}
+
+// WithCryptoKey включает шифрование тел HTTP-запросов открытым ключом сервера.
+func WithCryptoKey(key *rsa.PublicKey) Option {
+	return func(a *Agent) {
+		a.sender.publicKey = key
+	}
 }
 
-func NewAgent(serverAddr string, pollInterval, reportInterval time.Duration) *Agent {
-	pollTicker := time.NewTicker(pollInterval)
-	reportTicker := time.NewTicker(reportInterval)
+// WithRetryBackoff задаёт паузы между повторными отправками.
+func WithRetryBackoff(backoff ...time.Duration) Option {
+	return func(a *Agent) {
+		a.sender.backoff = backoff
+	}
+}
+
+// WithRateLimit ограничивает число одновременных запросов к серверу.
+func WithRateLimit(n int) Option {
+	return func(a *Agent) {
+		if n > 0 {
+			a.rateLimit = n
+		}
+	}
+}
 
-	return &Agent{
-		collector:    NewCollector(),
-		sender:       NewSender(serverAddr),
-		pollTicker:   pollTicker,
-		reportTicker: reportTicker,
-		stopCh:       make(chan struct{}),
+// WithProcRoot задаёт корень procfs для сбора системных метрик.
+// Пустая строка отключает системный коллектор.
+func WithProcRoot(root string) Option {
+	return func(a *Agent) {
+		if root == "" {
+			a.system = nil
+			return
+		}
+		a.system = NewSystemCollector(root)
 	}
 }
 
+// WithGCPauseBuckets задаёт границы корзин гистограммы пауз GC в секундах.
+func WithGCPauseBuckets(bounds []float64) Option {
+	return func(a *Agent) {
+		if len(bounds) > 0 {
+			a.collector.SetGCPauseBuckets(bounds)
+		}
+	}
+}
+
+// WithGRPC отправляет пакеты по gRPC на addr вместо HTTP.
+func WithGRPC(addr string) Option {
+	return func(a *Agent) {
+		a.sender.UseGRPC(addr)
+	}
+}
+
+// WithInstance задаёт метку instance. По умолчанию она совпадает
+// с именем хоста.
+func WithInstance(name string) Option {
+	return func(a *Agent) {
+		if name != "" {
+			a.labels["instance"] = name
+		}
+	}
+}
+
+func NewAgent(serverAddr string, pollInterval, reportInterval time.Duration, opts ...Option) *Agent {
+	a := &Agent{
+		collector:      NewCollector(),
+		sender:         NewSender(serverAddr),
+		pollInterval:   pollInterval,
+		reportInterval: reportInterval,
+		rateLimit:      defaultRateLimit,
+		labels:         make(map[string]string),
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		a.labels["host"] = host
+		a.labels["instance"] = host
+	} else {
+		log.Printf("failed to get hostname: %v", err)
+	}
+	if runtime.GOOS == "linux" {
+		a.system = NewSystemCollector(defaultProcRoot)
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
 func (a *Agent) Stop() {
-	if a.pollTicker != nil {
-		a.pollTicker.Stop()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cancel != nil {
+		a.cancel()
+	}
+}
+
+// Run запускает опрос, формирование отчётов и пул из rateLimit
+// отправителей. После отмены ctx или вызова Stop опрос и формирование
+// отчётов прекращаются, а отправители дожидаются начатых запросов,
+// отправляют оставшиеся в очереди пакеты и последний отчёт. Так
+// и при остановке к серверу идёт не больше rateLimit запросов сразу.
+// На всё это отводится finalReportTimeout.
+func (a *Agent) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	a.mu.Lock()
+	a.cancel = cancel
+	a.mu.Unlock()
+
+	// Запросы не прерываются вместе с ctx: иначе сервер ещё обрабатывал
+	// бы их, когда уходит последний отчёт.
+	sendCtx, sendCancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer sendCancel()
+
+	jobs := make(chan []models.Metrics, a.rateLimit)
+
+	a.collector.Poll()
+	a.pollSystem()
+	jobs <- a.snapshot()
+
+	var workers sync.WaitGroup
+	for i := 0; i < a.rateLimit; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			a.work(sendCtx, jobs)
+		}()
 	}
-	if a.reportTicker != nil {
-		a.reportTicker.Stop()
+
+	var producers sync.WaitGroup
+	producers.Add(1)
+	go func() {
+		defer producers.Done()
+		a.poll(ctx, a.collector.Poll)
+	}()
+
+	if a.system != nil {
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			a.poll(ctx, a.pollSystem)
+		}()
 	}
+
+	producers.Add(1)
+	go func() {
+		defer producers.Done()
+		a.produce(ctx, jobs)
+	}()
+
+	producers.Wait()
+
+	timer := time.AfterFunc(finalReportTimeout, sendCancel)
+	defer timer.Stop()
+	final := a.snapshot()
 	select {
-	case <-a.stopCh:
-	default:
-		close(a.stopCh)
+	case jobs <- final:
+	case <-sendCtx.Done():
+		a.collector.Rollback(final)
+	}
+	close(jobs)
+	workers.Wait()
+
+	if err := a.sender.Close(); err != nil {
+		log.Printf("failed to close sender: %v", err)
 	}
 }
 
-func (a *Agent) Run() {
-	metrics := a.collector.Collect()
-	for _, m := range metrics {
-		if err := a.sender.Send(m); err != nil {
-			log.Printf("failed to send metric %s: %v", m.ID, err)
+func (a *Agent) poll(ctx context.Context, fn func()) {
+	ticker := time.NewTicker(a.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn()
 		}
 	}
+}
+
+func (a *Agent) pollSystem() {
+	if a.system == nil {
+		return
+	}
+	if err := a.system.Poll(); err != nil {
+		log.Printf("failed to collect system metrics: %v", err)
+	}
+}
+
+// snapshot объединяет метрики runtime и системного коллектора
+// в один пакет и проставляет им метки агента.
+func (a *Agent) snapshot() []models.Metrics {
+	batch := a.collector.Snapshot()
+	if a.system != nil {
+		batch = append(batch, a.system.Snapshot()...)
+	}
+	if len(a.labels) > 0 {
+		for i := range batch {
+			batch[i].Labels = a.labels
+		}
+	}
+	return batch
+}
+
+func (a *Agent) produce(ctx context.Context, jobs chan<- []models.Metrics) {
+	ticker := time.NewTicker(a.reportInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-a.stopCh:
+		case <-ctx.Done():
 			return
-		case <-a.pollTicker.C:
-			a.collector.Collect()
-		case <-a.reportTicker.C:
-			metrics := a.collector.Collect()
-			for _, m := range metrics {
-				if err := a.sender.Send(m); err != nil {
-					log.Printf("failed to send metric %s: %v", m.ID, err)
-				}
-			}
+		case <-ticker.C:
 		}
+
+		batch := a.snapshot()
+		select {
+		case jobs <- batch:
+		case <-ctx.Done():
+			a.collector.Rollback(batch)
+			return
+		}
+	}
+}
+
+// work отправляет пакеты из jobs, пока канал не закрыт. После отмены
+// ctx пакеты не отправляются, а возвращаются в коллектор.
+func (a *Agent) work(ctx context.Context, jobs <-chan []models.Metrics) {
+	for batch := range jobs {
+		if ctx.Err() != nil {
+			a.collector.Rollback(batch)
+			continue
+		}
+		a.report(ctx, batch)
+	}
+}
+
+// report отправляет пакет. Если сервер не принял пакет и отправитель
+// не взял его в буфер, дельты счётчиков возвращаются в коллектор.
+func (a *Agent) report(ctx context.Context, metrics []models.Metrics) {
+	err := a.sender.SendBatch(ctx, metrics)
+	if err != nil && !errors.Is(err, ErrBuffered) {
+		a.collector.Rollback(metrics)
+	}
+	if err != nil {
+		log.Printf("failed to send metrics batch of %d: %v", len(metrics), err)
 	}
 }
@@ -1,12 +1,20 @@
 package agent
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/internal/server"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCollectorCollect(t *testing.T) {
@@ -42,7 +50,7 @@ func TestAgentRun(t *testing.T) {
 
 	done := make(chan bool)
 	go func() {
-		agent.Run()
+		agent.Run(context.Background())
 		done <- true
 	}()
 
@@ -55,3 +63,216 @@ func TestAgentRun(t *testing.T) {
 		t.Fatal("agent did not stop")
 	}
 }
+
+func pollCount(metrics []models.Metrics) int64 {
+	for _, m := range metrics {
+		if m.ID == "PollCount" {
+			return *m.Delta
+		}
+	}
+	return -1
+}
+
+func TestCollectorRollback(t *testing.T) {
+	collector := NewCollector()
+	collector.Poll()
+	collector.Poll()
+
+	snapshot := collector.Snapshot()
+	assert.Equal(t, int64(2), pollCount(snapshot))
+
+	collector.Poll()
+	collector.Rollback(snapshot)
+	assert.Equal(t, int64(3), pollCount(collector.Snapshot()))
+	assert.Equal(t, int64(0), pollCount(collector.Snapshot()))
+}
+
+func gcPauses(metrics []models.Metrics) *models.HistogramValue {
+	for _, m := range metrics {
+		if m.ID == gcPauseMetric {
+			return m.Histogram
+		}
+	}
+	return nil
+}
+
+func TestCollectorGCPauses(t *testing.T) {
+	collector := NewCollector()
+	collector.SetGCPauseBuckets([]float64{0.001, 1})
+	collector.Poll()
+	collector.Snapshot()
+
+	runtime.GC()
+	runtime.GC()
+	collector.Poll()
+
+	snapshot := collector.Snapshot()
+	pauses := gcPauses(snapshot)
+	require.NotNil(t, pauses)
+	assert.Equal(t, []float64{0.001, 1}, pauses.Bounds)
+	assert.GreaterOrEqual(t, pauses.Count, uint64(2))
+	assert.NoError(t, pauses.Validate())
+
+	collector.Rollback(snapshot)
+	assert.Equal(t, pauses.Count, gcPauses(collector.Snapshot()).Count)
+	assert.Equal(t, uint64(0), gcPauses(collector.Snapshot()).Count)
+}
+
+func TestAgentRateLimit(t *testing.T) {
+	const rateLimit = 2
+
+	var inFlight, maxInFlight, requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		requests.Add(1)
+		time.Sleep(30 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	a := NewAgent(srv.URL, time.Millisecond, 2*time.Millisecond, WithRateLimit(rateLimit))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("agent did not stop")
+	}
+
+	assert.Greater(t, requests.Load(), int32(rateLimit))
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(rateLimit))
+}
+
+func TestAgentReportsPollCountDeltas(t *testing.T) {
+	store := storage.NewMemStorage()
+	router := server.New(store).Router()
+
+	var status atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := int(status.Load()); code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+		router.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	a := NewAgent(srv.URL, time.Hour, time.Hour, WithRetryBackoff())
+	report := func() {
+		a.report(context.Background(), a.collector.Snapshot())
+	}
+
+	polls := 0
+	poll := func(n int) {
+		for i := 0; i < n; i++ {
+			a.collector.Poll()
+			polls++
+		}
+	}
+
+	poll(3)
+	report()
+	poll(2)
+	report()
+
+	status.Store(http.StatusBadRequest)
+	poll(4)
+	report()
+
+	status.Store(http.StatusServiceUnavailable)
+	poll(2)
+	report()
+	poll(1)
+	report()
+	status.Store(0)
+
+	poll(1)
+	report()
+	report()
+
+	v, ok := store.GetCounter("PollCount")
+	assert.True(t, ok)
+	assert.Equal(t, int64(polls), v)
+}
+
+func TestAgentSendsFinalReport(t *testing.T) {
+	store := storage.NewMemStorage()
+	srv := httptest.NewServer(server.New(store).Router())
+	defer srv.Close()
+
+	a := NewAgent(srv.URL, 5*time.Millisecond, time.Hour, WithProcRoot(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	a.Run(ctx)
+
+	v, ok := store.GetCounter(models.Key("PollCount", a.labels))
+	assert.True(t, ok)
+	assert.Greater(t, v, int64(1), "polls after the first report must be flushed on shutdown")
+	assert.Equal(t, int64(0), pollCount(a.collector.Snapshot()))
+}
+
+func TestAgentFinalReportRespectsRateLimit(t *testing.T) {
+	store := storage.NewMemStorage()
+	router := server.New(store).Router()
+
+	// Каждый запрос обрабатывается дольше, чем живёт агент: остановка
+	// всегда застаёт запрос в обработке.
+	var inFlight, maxInFlight, requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		requests.Add(1)
+		time.Sleep(80 * time.Millisecond)
+		router.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	a := NewAgent(srv.URL, time.Millisecond, 5*time.Millisecond, WithProcRoot(""), WithRateLimit(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 40*time.Millisecond)
+	defer cancel()
+	a.Run(ctx)
+
+	assert.Equal(t, int32(1), maxInFlight.Load(), "final report waits for the request in flight")
+	assert.GreaterOrEqual(t, requests.Load(), int32(2))
+	v, ok := store.GetCounter(models.Key("PollCount", a.labels))
+	assert.True(t, ok)
+	assert.Greater(t, v, int64(1))
+	assert.Equal(t, int64(0), pollCount(a.collector.Snapshot()), "nothing is left undelivered")
+}
+
+func TestAgentLabelsMetrics(t *testing.T) {
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	a := NewAgent("http://localhost", time.Second, time.Second, WithProcRoot(""), WithInstance("web-1"))
+	a.collector.Poll()
+
+	batch := a.snapshot()
+	require.NotEmpty(t, batch)
+	for _, m := range batch {
+		assert.Equal(t, map[string]string{"host": host, "instance": "web-1"}, m.Labels, m.ID)
+	}
+}
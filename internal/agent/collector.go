@@ -4,27 +4,80 @@ import (
 	"github.com/LemuriiL/MetricsAllerts/internal/model"
 	"math/rand"
 	"runtime"
+	"sync"
 )
 
+const gcPauseMetric = "GCPauseSeconds"
+
+// Collector опрашивает runtime и накапливает дельты счётчиков
+// и гистограммы пауз GC с момента последнего подтверждённого отчёта.
 type Collector struct {
-	pollCount   int64
+	mu          sync.Mutex
+	memStats    runtime.MemStats
 	randomValue float64
+	counters    map[string]int64
+	numGC       uint32
+	gcPauses    *models.HistogramValue
 }
 
 func NewCollector() *Collector {
 	return &Collector{
-		pollCount:   0,
-		randomValue: 0.0,
+		counters: make(map[string]int64),
+		gcPauses: models.NewHistogram(models.DefaultBuckets),
 	}
 }
 
-func (c *Collector) Collect() []models.Metrics {
-	c.pollCount++
-	c.randomValue = rand.Float64() * 100.0
+// SetGCPauseBuckets задаёт границы корзин гистограммы пауз GC
+// в секундах. Накопленные наблюдения сбрасываются.
+func (c *Collector) SetGCPauseBuckets(bounds []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gcPauses = models.NewHistogram(bounds)
+}
 
+func (c *Collector) Poll() {
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.memStats = memStats
+	c.randomValue = rand.Float64() * 100.0
+	c.counters["PollCount"]++
+	c.observePauses(&memStats)
+}
+
+// observePauses добавляет в гистограмму паузы GC, случившиеся после
+// прошлого опроса. runtime хранит только последние len(PauseNs) пауз,
+// более старые теряются.
+func (c *Collector) observePauses(memStats *runtime.MemStats) {
+	n := memStats.NumGC - c.numGC
+	if n > uint32(len(memStats.PauseNs)) {
+		n = uint32(len(memStats.PauseNs))
+	}
+	for i := uint32(0); i < n; i++ {
+		idx := (memStats.NumGC - 1 - i) % uint32(len(memStats.PauseNs))
+		c.gcPauses.Observe(float64(memStats.PauseNs[idx]) / 1e9)
+	}
+	c.numGC = memStats.NumGC
+}
+
+// Collect выполняет опрос и возвращает снимок метрик.
+func (c *Collector) Collect() []models.Metrics {
+	c.Poll()
+	return c.Snapshot()
+}
+
+// Snapshot возвращает последние значения gauge и накопленные
+// дельты счётчиков. Дельты забираются из коллектора; если пакет
+// не удалось доставить, их нужно вернуть через Rollback.
+func (c *Collector) Snapshot() []models.Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	memStats := &c.memStats
+
 	gauge := func(name string, value float64) models.Metrics {
 		return models.Metrics{
 			ID:    name,
@@ -41,6 +94,8 @@ func (c *Collector) Collect() []models.Metrics {
 		}
 	}
 
+	pauses := c.gcPauses.Clone()
+
 	metrics := []models.Metrics{
 		gauge("Alloc", float64(memStats.Alloc)),
 		gauge("BuckHashSys", float64(memStats.BuckHashSys)),
@@ -70,8 +125,30 @@ func (c *Collector) Collect() []models.Metrics {
 		gauge("Sys", float64(memStats.Sys)),
 		gauge("TotalAlloc", float64(memStats.TotalAlloc)),
 		gauge("RandomValue", c.randomValue),
-		counter("PollCount", c.pollCount),
+		counter("PollCount", c.counters["PollCount"]),
+		{ID: gcPauseMetric, MType: models.Histogram, Histogram: &pauses},
+	}
+
+	for name := range c.counters {
+		c.counters[name] = 0
 	}
+	c.gcPauses = models.NewHistogram(pauses.Bounds)
 
 	return metrics
 }
+
+// Rollback возвращает в коллектор дельты недоставленного пакета.
+// Гистограмма со старыми границами корзин отбрасывается.
+func (c *Collector) Rollback(unsent []models.Metrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, m := range unsent {
+		if m.MType == models.Counter && m.Delta != nil {
+			c.counters[m.ID] += *m.Delta
+		}
+		if m.MType == models.Histogram && m.ID == gcPauseMetric && m.Histogram != nil {
+			_ = c.gcPauses.Merge(*m.Histogram)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/pkg/metricspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grpcTimeout = 5 * time.Second
+	// streamChunkSize — размер части пакета в StreamMetrics. Пакеты
+	// не больше одной части отправляются через UpdateMetrics.
+	streamChunkSize = 500
+)
+
+// grpcTransport отправляет пакеты в MetricsService. Соединение
+// устанавливается при первой отправке.
+type grpcTransport struct {
+	addr   string
+	realIP *realIP
+
+	mu     sync.Mutex
+	conn   *grpc.ClientConn
+	client metricspb.MetricsServiceClient
+}
+
+func newGRPCTransport(addr string) *grpcTransport {
+	return &grpcTransport{addr: addr, realIP: &realIP{addr: addr}}
+}
+
+func (t *grpcTransport) send(ctx context.Context, batch []models.Metrics) error {
+	client, err := t.dial()
+	if err != nil {
+		return err
+	}
+
+	metrics := make([]*metricspb.Metric, len(batch))
+	for i, m := range batch {
+		metrics[i] = models.ToProto(m)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, grpcTimeout)
+	defer cancel()
+	if ip := t.realIP.get(); ip != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-real-ip", ip)
+	}
+
+	if len(metrics) <= streamChunkSize {
+		_, err = client.UpdateMetrics(ctx, &metricspb.UpdateMetricsRequest{Metrics: metrics})
+	} else {
+		err = stream(ctx, client, metrics)
+	}
+	return grpcError(err)
+}
+
+func stream(ctx context.Context, client metricspb.MetricsServiceClient, metrics []*metricspb.Metric) error {
+	st, err := client.StreamMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	for len(metrics) > 0 {
+		n := min(streamChunkSize, len(metrics))
+		if err := st.Send(&metricspb.UpdateMetricsRequest{Metrics: metrics[:n]}); err != nil {
+			// Причину обрыва потока сообщает CloseAndRecv.
+			break
+		}
+		metrics = metrics[n:]
+	}
+	_, err = st.CloseAndRecv()
+	return err
+}
+
+// grpcError помечает временные ошибки так же, как HTTP-отправка
+// помечает сетевые ошибки и ответы 5xx.
+func grpcError(err error) error {
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.Internal:
+		return retriableError{err}
+	}
+	return err
+}
+
+func (t *grpcTransport) dial() (metricspb.MetricsServiceClient, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+	conn, err := grpc.NewClient(t.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	t.client = metricspb.NewMetricsServiceClient(conn)
+	return t.client, nil
+}
+
+func (t *grpcTransport) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn, t.client = nil, nil
+	return err
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/internal/server"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startGRPCServer(t *testing.T, store storage.Storage, opts ...server.Option) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.New(store, opts...).ServeGRPC(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+func TestAgentGRPC(t *testing.T) {
+	store := storage.NewMemStorage()
+	addr := startGRPCServer(t, store, server.WithKey("secret"))
+
+	a := NewAgent("", 5*time.Millisecond, 20*time.Millisecond,
+		WithProcRoot(""), WithGRPC(addr), WithKey("secret"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	a.Run(ctx)
+
+	v, ok := store.GetCounter(models.Key("PollCount", a.labels))
+	assert.True(t, ok)
+	assert.Greater(t, v, int64(1))
+	_, ok = store.GetHistogram(models.Key(gcPauseMetric, a.labels))
+	assert.True(t, ok)
+}
+
+func TestSenderGRPCStreamsLargeBatch(t *testing.T) {
+	store := storage.NewMemStorage()
+	sender := NewSender("")
+	sender.UseGRPC(startGRPCServer(t, store))
+	defer sender.Close()
+
+	batch := make([]models.Metrics, 2*streamChunkSize+1)
+	for i := range batch {
+		v := float64(i)
+		batch[i] = models.Metrics{ID: fmt.Sprintf("g%d", i), MType: models.Gauge, Value: &v}
+	}
+	require.NoError(t, sender.SendBatch(context.Background(), batch))
+	assert.Len(t, store.GetAllGauges(), len(batch))
+}
+
+func TestSenderGRPCBuffersWhenUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	sender := NewSender("")
+	sender.backoff = nil
+	sender.UseGRPC(addr)
+	defer sender.Close()
+
+	d := int64(1)
+	err = sender.SendBatch(context.Background(), []models.Metrics{{ID: "PollCount", MType: models.Counter, Delta: &d}})
+	assert.ErrorIs(t, err, ErrBuffered)
+	assert.Len(t, sender.buffer, 1)
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"log"
+	"net"
+	"net/url"
+	"sync"
+)
+
+// realIP определяет адрес интерфейса, через который агент ходит
+// на сервер. Адрес вычисляется один раз, при первом обращении.
+type realIP struct {
+	addr string
+
+	once sync.Once
+	ip   string
+}
+
+func (r *realIP) get() string {
+	r.once.Do(func() {
+		ip, err := outboundIP(r.addr)
+		if err != nil {
+			log.Printf("failed to detect outbound address: %v", err)
+			return
+		}
+		r.ip = ip
+	})
+	return r.ip
+}
+
+// outboundIP возвращает локальный адрес маршрута до addr. UDP-«соединение»
+// только выбирает маршрут, пакеты при этом не отправляются.
+func outboundIP(addr string) (string, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// hostPort приводит адрес сервера (http://host:port или host:port)
+// к виду host:port.
+func hostPort(serverAddr string) string {
+	u, err := url.Parse(serverAddr)
+	if err != nil || u.Host == "" {
+		return serverAddr
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -3,30 +3,229 @@ package agent
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/LemuriiL/MetricsAllerts/internal/encryption"
+	"github.com/LemuriiL/MetricsAllerts/internal/hash"
 	"github.com/LemuriiL/MetricsAllerts/internal/model"
 )
 
+const defaultMaxBuffered = 1000
+
+// realIPHeader сообщает серверу адрес агента для проверки доверенной
+// подсети.
+const realIPHeader = "X-Real-IP"
+
+// ErrBuffered означает, что пакет не доставлен, но сохранён
+// в буфере и будет отправлен вместе со следующим.
+var ErrBuffered = errors.New("metrics buffered")
+
+var defaultBackoff = []time.Duration{time.Second, 3 * time.Second, 5 * time.Second}
+
 type Sender struct {
 	serverAddr string
+	key        string
+	publicKey  *rsa.PublicKey
 	client     *http.Client
+	backoff    []time.Duration
+	sleep      func(context.Context, time.Duration) error
+	// transport доставляет подписанный пакет: по умолчанию POST /updates/,
+	// в gRPC-режиме — вызов MetricsService.
+	transport func(context.Context, []models.Metrics) error
+	grpc      *grpcTransport
+	realIP    *realIP
+
+	mu          sync.Mutex
+	buffer      []models.Metrics
+	maxBuffered int
 }
 
 func NewSender(serverAddr string) *Sender {
-	return &Sender{
+	s := &Sender{
 		serverAddr: serverAddr,
 		client: &http.Client{
 			Timeout: 5 * time.Second,
 		},
+		backoff:     defaultBackoff,
+		sleep:       sleep,
+		maxBuffered: defaultMaxBuffered,
+		realIP:      &realIP{addr: hostPort(serverAddr)},
+	}
+	s.transport = s.postBatch
+	return s
+}
+
+// UseGRPC переключает отправку пакетов на gRPC-сервер addr.
+func (s *Sender) UseGRPC(addr string) {
+	s.grpc = newGRPCTransport(addr)
+	s.transport = s.grpc.send
+}
+
+// Close закрывает gRPC-соединение, если оно было открыто.
+func (s *Sender) Close() error {
+	if s.grpc == nil {
+		return nil
+	}
+	return s.grpc.close()
+}
+
+func (s *Sender) Send(ctx context.Context, metric models.Metrics) error {
+	if s.key != "" {
+		metric.Hash = hash.Metric(s.key, metric)
+	}
+	return s.retry(ctx, func() error {
+		return s.post(ctx, "/update", metric)
+	})
+}
+
+// SendBatch отправляет пакет вместе с ранее не доставленными метриками.
+// Если сервер недоступен, повторы исчерпаны или ctx отменён, пакет
+// остаётся в буфере до следующего вызова: дельты счётчиков суммируются,
+// для gauge сохраняется последнее значение. Если сервер отверг пакет,
+// возвращается ошибка, а ранее буферизованные метрики остаются в буфере:
+// откатить metrics — забота вызывающего. Безопасен для вызова
+// из нескольких горутин.
+func (s *Sender) SendBatch(ctx context.Context, metrics []models.Metrics) error {
+	s.mu.Lock()
+	buffered := s.buffer
+	pending := make([]models.Metrics, 0, len(buffered)+len(metrics))
+	pending = append(pending, buffered...)
+	pending = append(pending, metrics...)
+	s.buffer = nil
+	s.mu.Unlock()
+
+	batch := models.Merge(pending)
+	if len(batch) == 0 {
+		return nil
+	}
+
+	signed := s.sign(batch)
+	err := s.retry(ctx, func() error {
+		return s.transport(ctx, signed)
+	})
+	if err == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !isRetriable(err) && ctx.Err() == nil {
+		s.buffer = trimBuffer(models.Merge(append(buffered, s.buffer...)), s.maxBuffered)
+		return err
+	}
+	s.buffer = trimBuffer(models.Merge(append(batch, s.buffer...)), s.maxBuffered)
+	return fmt.Errorf("%w: %d metrics: %w", ErrBuffered, len(s.buffer), err)
+}
+
+// trimBuffer ограничивает буфер limit метриками. В первую очередь
+// отбрасываются gauge: их значение обновится при следующем опросе,
+// а потерянную дельту счётчика восстановить нельзя.
+func trimBuffer(batch []models.Metrics, limit int) []models.Metrics {
+	excess := len(batch) - limit
+	if excess <= 0 {
+		return batch
+	}
+	log.Printf("send buffer is full, dropping %d metrics", excess)
+
+	res := make([]models.Metrics, 0, limit)
+	for _, m := range batch {
+		if excess > 0 && m.MType == models.Gauge {
+			excess--
+			continue
+		}
+		res = append(res, m)
+	}
+	return res[excess:]
+}
+
+func (s *Sender) sign(metrics []models.Metrics) []models.Metrics {
+	if s.key == "" {
+		return metrics
+	}
+	signed := make([]models.Metrics, len(metrics))
+	for i, m := range metrics {
+		m.Hash = hash.Metric(s.key, m)
+		signed[i] = m
+	}
+	return signed
+}
+
+// retry повторяет fn с паузами из s.backoff, пока ошибка остаётся
+// временной.
+func (s *Sender) retry(ctx context.Context, fn func() error) error {
+	err := fn()
+	for _, d := range s.backoff {
+		if err == nil || !isRetriable(err) {
+			return err
+		}
+		if serr := s.sleep(ctx, d); serr != nil {
+			return err
+		}
+		err = fn()
+	}
+	return err
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
 	}
 }
 
-func (s *Sender) Send(metric models.Metrics) error {
-	raw, err := json.Marshal(metric)
+type retriableError struct {
+	err error
+}
+
+func (e retriableError) Error() string { return e.err.Error() }
+func (e retriableError) Unwrap() error { return e.err }
+
+func isRetriable(err error) bool {
+	var re retriableError
+	return errors.As(err, &re)
+}
+
+// isTransient сообщает, что ошибка сети может пройти сама: сервер
+// ещё запускается, его имя ещё не появилось в DNS или маршрут временно
+// недоступен.
+func isTransient(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	var de *net.DNSError
+	if errors.As(err, &de) && (de.IsTemporary || de.IsNotFound) {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (s *Sender) postBatch(ctx context.Context, batch []models.Metrics) error {
+	return s.post(ctx, "/updates/", batch)
+}
+
+func (s *Sender) post(ctx context.Context, path string, payload any) error {
+	raw, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
@@ -42,23 +241,46 @@ func (s *Sender) Send(metric models.Metrics) error {
 		return err
 	}
 
-	url := fmt.Sprintf("%s/update", s.serverAddr)
+	body := buf.Bytes()
+	if s.publicKey != nil {
+		body, err = encryption.Encrypt(s.publicKey, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	url := fmt.Sprintf("%s%s", s.serverAddr, path)
 
-	req, err := http.NewRequest("POST", url, &buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
+	if s.publicKey != nil {
+		req.Header.Set(encryption.Header, encryption.Scheme)
+	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Content-Encoding", "gzip")
 	req.Header.Set("Accept-Encoding", "gzip")
+	if ip := s.realIP.get(); ip != "" {
+		req.Header.Set(realIPHeader, ip)
+	}
+	if s.key != "" {
+		req.Header.Set(hash.Header, hash.Sign(s.key, raw))
+	}
 
 	resp, err := s.client.Do(req)
 	if err != nil {
+		if isTransient(err) {
+			return retriableError{err}
+		}
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return retriableError{fmt.Errorf("server returned status: %d", resp.StatusCode)}
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("server returned status: %d", resp.StatusCode)
 	}
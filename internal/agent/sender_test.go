@@ -1,12 +1,26 @@
 package agent
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/LemuriiL/MetricsAllerts/internal/encryption"
+	"github.com/LemuriiL/MetricsAllerts/internal/hash"
 	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/internal/server"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSenderSendGauge(t *testing.T) {
@@ -23,7 +37,7 @@ func TestSenderSendGauge(t *testing.T) {
 		Value: &val,
 	}
 
-	err := sender.Send(metric)
+	err := sender.Send(context.Background(), metric)
 	assert.NoError(t, err)
 }
 
@@ -41,6 +55,315 @@ func TestSenderSendCounter(t *testing.T) {
 		Delta: &delta,
 	}
 
-	err := sender.Send(metric)
+	err := sender.Send(context.Background(), metric)
 	assert.NoError(t, err)
 }
+
+func TestSenderSendBatch(t *testing.T) {
+	var received []models.Metrics
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/updates/", r.URL.Path)
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+
+		zr, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.NewDecoder(zr).Decode(&received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := NewSender(server.URL)
+	val := 42.5
+	delta := int64(3)
+	err := sender.SendBatch(context.Background(), []models.Metrics{
+		{ID: "TestGauge", MType: models.Gauge, Value: &val},
+		{ID: "TestCounter", MType: models.Counter, Delta: &delta},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests)
+	assert.Len(t, received, 2)
+
+	assert.NoError(t, sender.SendBatch(context.Background(), nil))
+	assert.Equal(t, 1, requests)
+}
+
+func TestSenderSignsRequests(t *testing.T) {
+	const key = "secret"
+
+	var received []models.Metrics
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		assert.NoError(t, err)
+
+		assert.True(t, hash.Verify(key, body, r.Header.Get(hash.Header)))
+		assert.NoError(t, json.NewDecoder(bytes.NewReader(body)).Decode(&received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := NewSender(server.URL)
+	sender.key = key
+
+	val := 42.5
+	metrics := []models.Metrics{{ID: "TestGauge", MType: models.Gauge, Value: &val}}
+	assert.NoError(t, sender.SendBatch(context.Background(), metrics))
+	assert.Empty(t, metrics[0].Hash)
+
+	if assert.Len(t, received, 1) {
+		assert.True(t, hash.VerifyMetric(key, received[0]))
+	}
+}
+
+func decodeBatch(t *testing.T, r *http.Request) []models.Metrics {
+	t.Helper()
+	zr, err := gzip.NewReader(r.Body)
+	assert.NoError(t, err)
+	var batch []models.Metrics
+	assert.NoError(t, json.NewDecoder(zr).Decode(&batch))
+	return batch
+}
+
+func TestSenderRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		calls    int
+		sleeps   []time.Duration
+		wantErr  bool
+		buffered int
+	}{
+		{
+			name:     "recovers after 5xx",
+			statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK},
+			calls:    3,
+			sleeps:   []time.Duration{time.Second, 3 * time.Second},
+		},
+		{
+			name:     "buffers after retries are exhausted",
+			statuses: []int{500, 500, 500, 500},
+			calls:    4,
+			sleeps:   defaultBackoff,
+			wantErr:  true,
+			buffered: 1,
+		},
+		{
+			name:     "fatal error is not retried",
+			statuses: []int{http.StatusBadRequest},
+			calls:    1,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[calls])
+				calls++
+			}))
+			defer server.Close()
+
+			var sleeps []time.Duration
+			sender := NewSender(server.URL)
+			sender.sleep = func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			val := 1.0
+			err := sender.SendBatch(context.Background(), []models.Metrics{{ID: "g", MType: models.Gauge, Value: &val}})
+
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.calls, calls)
+			assert.Equal(t, tt.sleeps, sleeps)
+			assert.Len(t, sender.buffer, tt.buffered)
+		})
+	}
+}
+
+func TestSenderBuffersWhileServerIsDown(t *testing.T) {
+	down := true
+	var received []models.Metrics
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received = decodeBatch(t, r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := NewSender(server.URL)
+	sender.backoff = nil
+
+	send := func(delta int64, value float64) error {
+		return sender.SendBatch(context.Background(), []models.Metrics{
+			{ID: "PollCount", MType: models.Counter, Delta: &delta},
+			{ID: "g", MType: models.Gauge, Value: &value},
+		})
+	}
+
+	assert.Error(t, send(2, 1))
+	assert.Error(t, send(3, 2))
+	assert.Len(t, sender.buffer, 2)
+
+	down = false
+	assert.NoError(t, send(4, 3))
+	assert.Empty(t, sender.buffer)
+
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, int64(9), *received[0].Delta)
+		assert.Equal(t, 3.0, *received[1].Value)
+	}
+}
+
+func TestSenderKeepsBufferOnRejectedBatch(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	var received []models.Metrics
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status == http.StatusOK {
+			received = decodeBatch(t, r)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sender := NewSender(server.URL)
+	sender.backoff = nil
+
+	d := int64(5)
+	err := sender.SendBatch(context.Background(), []models.Metrics{{ID: "PollCount", MType: models.Counter, Delta: &d}})
+	assert.ErrorIs(t, err, ErrBuffered)
+
+	status = http.StatusBadRequest
+	v := 1.0
+	err = sender.SendBatch(context.Background(), []models.Metrics{{ID: "g", MType: models.Gauge, Value: &v}})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrBuffered, "the caller rolls back its own batch")
+	if assert.Len(t, sender.buffer, 1, "earlier deltas stay buffered") {
+		assert.Equal(t, int64(5), *sender.buffer[0].Delta)
+	}
+
+	status = http.StatusOK
+	require.NoError(t, sender.SendBatch(context.Background(), nil))
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, int64(5), *received[0].Delta)
+	}
+}
+
+func TestSenderConnectionRefusedIsRetriable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	addr := server.URL
+	server.Close()
+
+	sender := NewSender(addr)
+	sender.backoff = []time.Duration{time.Millisecond}
+	sender.sleep = func(context.Context, time.Duration) error { return nil }
+	sender.maxBuffered = 1
+
+	d := int64(1)
+	v := 1.0
+	err := sender.SendBatch(context.Background(), []models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: &d},
+		{ID: "g", MType: models.Gauge, Value: &v},
+	})
+	assert.True(t, isRetriable(err))
+	if assert.Len(t, sender.buffer, 1) {
+		assert.Equal(t, "PollCount", sender.buffer[0].ID)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Post", URL: "http://metrics:8080/updates/", Err: err}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", wrap(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), true},
+		{"host unreachable", wrap(&net.OpError{Op: "dial", Err: syscall.EHOSTUNREACH}), true},
+		{"network unreachable", wrap(&net.OpError{Op: "dial", Err: syscall.ENETUNREACH}), true},
+		{"dns not found", wrap(&net.DNSError{Err: "no such host", Name: "metrics", IsNotFound: true}), true},
+		{"dns temporary", wrap(&net.DNSError{Err: "server misbehaving", Name: "metrics", IsTemporary: true}), true},
+		{"dns other", wrap(&net.DNSError{Err: "invalid name", Name: "metrics"}), false},
+		{"permission denied", wrap(&net.OpError{Op: "dial", Err: syscall.EACCES}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isTransient(tt.err))
+		})
+	}
+}
+
+func TestSenderBuffersOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sender := NewSender(server.URL)
+	d := int64(5)
+	err := sender.SendBatch(ctx, []models.Metrics{{ID: "PollCount", MType: models.Counter, Delta: &d}})
+	assert.ErrorIs(t, err, ErrBuffered)
+	assert.Len(t, sender.buffer, 1)
+}
+
+func TestSenderEncryptsBatch(t *testing.T) {
+	priv, err := encryption.GenerateKey(2048)
+	require.NoError(t, err)
+
+	store := storage.NewMemStorage()
+	router := server.New(store, server.WithCryptoKey(priv), server.WithKey("secret")).Router()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, encryption.Scheme, r.Header.Get(encryption.Header))
+		assert.NotContains(t, string(body), "Alloc")
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		router.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	sender := NewSender(srv.URL)
+	sender.key = "secret"
+	sender.publicKey = &priv.PublicKey
+
+	val := 1.5
+	err = sender.SendBatch(context.Background(), []models.Metrics{{ID: "Alloc", MType: models.Gauge, Value: &val}})
+	require.NoError(t, err)
+
+	v, ok := store.GetGauge("Alloc")
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, v)
+}
+
+func TestSenderSetsRealIP(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(realIPHeader)
+	}))
+	defer srv.Close()
+
+	val := 1.5
+	err := NewSender(srv.URL).SendBatch(context.Background(), []models.Metrics{{ID: "Alloc", MType: models.Gauge, Value: &val}})
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", got)
+}
+
+func TestHostPort(t *testing.T) {
+	assert.Equal(t, "localhost:8080", hostPort("http://localhost:8080"))
+	assert.Equal(t, "example.com:443", hostPort("https://example.com"))
+	assert.Equal(t, "example.com:80", hostPort("http://example.com/"))
+	assert.Equal(t, "localhost:3200", hostPort("localhost:3200"))
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package agent
+
+import "errors"
+
+func statfs(path string) (total, free uint64, err error) {
+	return 0, 0, errors.New("statfs is not supported on this platform")
+}
@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+const defaultProcRoot = "/proc"
+
+// pseudoFS — файловые системы без реального хранилища,
+// для которых не имеет смысла считать занятость диска.
+var pseudoFS = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true,
+	"cgroup2": true, "configfs": true, "debugfs": true, "devpts": true,
+	"devtmpfs": true, "fusectl": true, "hugetlbfs": true, "mqueue": true,
+	"nsfs": true, "proc": true, "pstore": true, "ramfs": true,
+	"rpc_pipefs": true, "securityfs": true, "squashfs": true, "sysfs": true,
+	"tmpfs": true, "tracefs": true,
+}
+
+type cpuTimes struct {
+	busy  uint64
+	total uint64
+}
+
+// SystemCollector собирает метрики хоста из /proc: память, загрузку
+// каждого ядра, занятость смонтированных дисков и трафик интерфейсов.
+type SystemCollector struct {
+	root   string
+	statfs func(path string) (total, free uint64, err error)
+
+	mu      sync.Mutex
+	gauges  map[string]float64
+	prevCPU map[int]cpuTimes
+}
+
+func NewSystemCollector(procRoot string) *SystemCollector {
+	return &SystemCollector{
+		root:    procRoot,
+		statfs:  statfs,
+		gauges:  make(map[string]float64),
+		prevCPU: make(map[int]cpuTimes),
+	}
+}
+
+// Poll перечитывает источники метрик. Источники читаются независимо:
+// если какой-то файл недоступен (например, /proc/net/dev в контейнере),
+// метрики остальных сохраняются, а ошибки возвращаются вместе.
+func (c *SystemCollector) Poll() error {
+	gauges := make(map[string]float64)
+	var errs []error
+
+	if err := c.readMemInfo(gauges); err != nil {
+		errs = append(errs, err)
+	}
+	cpu, err := c.readCPU()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.readMounts(gauges); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.readNetDev(gauges); err != nil {
+		errs = append(errs, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cpu != nil {
+		for n, cur := range cpu {
+			prev, ok := c.prevCPU[n]
+			if ok && cur.total > prev.total {
+				util := float64(cur.busy-prev.busy) / float64(cur.total-prev.total) * 100
+				gauges[fmt.Sprintf("CPUutilization%d", n+1)] = util
+			}
+		}
+		c.prevCPU = cpu
+	}
+	c.gauges = gauges
+	return errors.Join(errs...)
+}
+
+// Snapshot возвращает gauge последнего опроса, отсортированные по имени.
+func (c *SystemCollector) Snapshot() []models.Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metrics := make([]models.Metrics, 0, len(c.gauges))
+	for name, v := range c.gauges {
+		value := v
+		metrics = append(metrics, models.Metrics{
+			ID:    name,
+			MType: models.Gauge,
+			Value: &value,
+		})
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].ID < metrics[j].ID })
+	return metrics
+}
+
+func (c *SystemCollector) readMemInfo(gauges map[string]float64) error {
+	f, err := os.Open(filepath.Join(c.root, "meminfo"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	names := map[string]string{
+		"MemTotal": "TotalMemory",
+		"MemFree":  "FreeMemory",
+	}
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, rest, ok := strings.Cut(sc.Text(), ":")
+		name, wanted := names[key]
+		if !ok || !wanted {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("meminfo %s: %w", key, err)
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			v *= 1024
+		}
+		gauges[name] = float64(v)
+	}
+	return sc.Err()
+}
+
+func (c *SystemCollector) readCPU() (map[int]cpuTimes, error) {
+	f, err := os.Open(filepath.Join(c.root, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	res := make(map[int]cpuTimes)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(fields[0], "cpu"))
+		if err != nil {
+			continue
+		}
+
+		var t cpuTimes
+		for i, s := range fields[1:] {
+			v, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("stat %s: %w", fields[0], err)
+			}
+			t.total += v
+			// idle и iowait — четвёртое и пятое поля.
+			if i != 3 && i != 4 {
+				t.busy += v
+			}
+		}
+		res[n] = t
+	}
+	return res, sc.Err()
+}
+
+func (c *SystemCollector) readMounts(gauges map[string]float64) error {
+	f, err := os.Open(filepath.Join(c.root, "mounts"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || pseudoFS[fields[2]] {
+			continue
+		}
+
+		mount := unescapeMount(fields[1])
+		suffix := mountSuffix(mount)
+		if _, seen := gauges["DiskTotal_"+suffix]; seen {
+			continue
+		}
+
+		total, free, err := c.statfs(mount)
+		if err != nil || total == 0 {
+			continue
+		}
+		gauges["DiskTotal_"+suffix] = float64(total)
+		gauges["DiskUsed_"+suffix] = float64(total - free)
+		gauges["DiskUtilization_"+suffix] = float64(total-free) / float64(total) * 100
+	}
+	return sc.Err()
+}
+
+func (c *SystemCollector) readNetDev(gauges map[string]float64) error {
+	f, err := os.Open(filepath.Join(c.root, "net", "dev"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		iface, rest, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		iface = strings.TrimSpace(iface)
+		fields := strings.Fields(rest)
+		if len(fields) < 9 {
+			continue
+		}
+
+		rx, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("net/dev %s: %w", iface, err)
+		}
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return fmt.Errorf("net/dev %s: %w", iface, err)
+		}
+		gauges["NetRxBytes_"+iface] = float64(rx)
+		gauges["NetTxBytes_"+iface] = float64(tx)
+	}
+	return sc.Err()
+}
+
+// mountSuffix превращает точку монтирования в часть имени метрики:
+// "/" → "root", "/var/lib" → "var_lib", "/mnt/my disk" → "mnt_my_disk".
+func mountSuffix(mount string) string {
+	s := strings.Trim(mount, "/")
+	if s == "" {
+		return "root"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '/' || unicode.IsSpace(r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// unescapeMount раскрывает восьмеричные последовательности вида \040,
+// которыми /proc/mounts записывает пробелы, табуляции и \ в путях.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
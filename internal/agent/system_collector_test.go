@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	fixtureMemInfo = `MemTotal:       16384000 kB
+MemFree:         2048000 kB
+MemAvailable:    8192000 kB
+`
+	fixtureMounts = `/dev/sda1 / ext4 rw,relatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+tmpfs /run tmpfs rw,nosuid,nodev 0 0
+/dev/sdb1 /var/lib/data xfs rw,relatime 0 0
+/dev/sdc1 /mnt/my\040disk ext4 rw,relatime 0 0
+/dev/sda1 / ext4 rw,relatime 0 0
+`
+	fixtureNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0:  500000    4000    0    0    0     0          0         0   250000    2000    0    0    0     0       0          0
+`
+	fixtureStat1 = `cpu  200 0 200 1600 0 0 0 0 0 0
+cpu0 100 0 100 800 0 0 0 0 0 0
+cpu1 100 0 100 800 0 0 0 0 0 0
+intr 12345
+`
+	fixtureStat2 = `cpu  500 0 300 2200 0 0 0 0 0 0
+cpu0 150 0 150 900 0 0 0 0 0 0
+cpu1 250 0 150 1000 100 0 0 0 0 0
+intr 12400
+`
+)
+
+func writeProcFixture(t *testing.T, root, name, data string) {
+	t.Helper()
+	path := filepath.Join(root, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+}
+
+func gaugeValues(metrics []models.Metrics) map[string]float64 {
+	res := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		res[m.ID] = *m.Value
+	}
+	return res
+}
+
+func TestSystemCollector(t *testing.T) {
+	root := t.TempDir()
+	writeProcFixture(t, root, "meminfo", fixtureMemInfo)
+	writeProcFixture(t, root, "mounts", fixtureMounts)
+	writeProcFixture(t, root, "net/dev", fixtureNetDev)
+	writeProcFixture(t, root, "stat", fixtureStat1)
+
+	c := NewSystemCollector(root)
+	var statted []string
+	c.statfs = func(path string) (uint64, uint64, error) {
+		statted = append(statted, path)
+		if path == "/var/lib/data" {
+			return 0, 0, errors.New("unavailable")
+		}
+		return 1000, 250, nil
+	}
+
+	require.NoError(t, c.Poll())
+	first := gaugeValues(c.Snapshot())
+	assert.NotContains(t, first, "CPUutilization1", "utilization needs two samples")
+
+	writeProcFixture(t, root, "stat", fixtureStat2)
+	require.NoError(t, c.Poll())
+
+	metrics := c.Snapshot()
+	for i := 1; i < len(metrics); i++ {
+		assert.Less(t, metrics[i-1].ID, metrics[i].ID)
+	}
+
+	expected := map[string]float64{
+		"TotalMemory":                 16384000 * 1024,
+		"FreeMemory":                  2048000 * 1024,
+		"CPUutilization1":             50,
+		"CPUutilization2":             40,
+		"DiskTotal_root":              1000,
+		"DiskUsed_root":               750,
+		"DiskUtilization_root":        75,
+		"DiskTotal_mnt_my_disk":       1000,
+		"DiskUsed_mnt_my_disk":        750,
+		"DiskUtilization_mnt_my_disk": 75,
+		"NetRxBytes_lo":               1000,
+		"NetTxBytes_lo":               1000,
+		"NetRxBytes_eth0":             500000,
+		"NetTxBytes_eth0":             250000,
+	}
+	assert.Equal(t, expected, gaugeValues(metrics))
+	assert.Equal(t, []string{"/", "/var/lib/data", "/mnt/my disk", "/", "/var/lib/data", "/mnt/my disk"}, statted)
+}
+
+func TestSystemCollectorMissingRoot(t *testing.T) {
+	c := NewSystemCollector(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, c.Poll())
+	assert.Empty(t, c.Snapshot())
+}
+
+func TestSystemCollectorMissingSource(t *testing.T) {
+	root := t.TempDir()
+	writeProcFixture(t, root, "meminfo", fixtureMemInfo)
+	writeProcFixture(t, root, "mounts", "")
+	writeProcFixture(t, root, "stat", fixtureStat1)
+
+	c := NewSystemCollector(root)
+	err := c.Poll()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	writeProcFixture(t, root, "stat", fixtureStat2)
+	assert.Error(t, c.Poll(), "net/dev is still missing")
+
+	assert.Equal(t, map[string]float64{
+		"TotalMemory":     16384000 * 1024,
+		"FreeMemory":      2048000 * 1024,
+		"CPUutilization1": 50,
+		"CPUutilization2": 40,
+	}, gaugeValues(c.Snapshot()))
+}
+
+func TestMountSuffix(t *testing.T) {
+	assert.Equal(t, "root", mountSuffix("/"))
+	assert.Equal(t, "var_lib_data", mountSuffix("/var/lib/data"))
+	assert.Equal(t, "mnt_my_disk", mountSuffix("/mnt/my disk"))
+}
+
+func TestUnescapeMount(t *testing.T) {
+	assert.Equal(t, "/mnt/my disk", unescapeMount(`/mnt/my\040disk`))
+	assert.Equal(t, "/a\tb\\c", unescapeMount(`/a\011b\134c`))
+	assert.Equal(t, `/odd\4`, unescapeMount(`/odd\4`), "short sequence is kept")
+}
@@ -0,0 +1,334 @@
+package alerting
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+)
+
+type State string
+
+const (
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+type Alert struct {
+	Rule       string            `json:"rule"`
+	Expr       string            `json:"expr"`
+	MetricID   string            `json:"id"`
+	MetricType string            `json:"type"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	State      State             `json:"state"`
+	Value      float64           `json:"value"`
+	ActiveAt   time.Time         `json:"active_at"`
+	FiredAt    *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time        `json:"resolved_at,omitempty"`
+}
+
+type sample struct {
+	value int64
+	at    time.Time
+}
+
+type Engine struct {
+	storage  storage.Storage
+	rules    []Rule
+	clock    Clock
+	notifier *Notifier
+
+	mu      sync.RWMutex
+	alerts  map[string]*Alert
+	samples map[string]sample
+	// resolved — алерты снятых правил, ещё не возвращённые Evaluate.
+	resolved []Alert
+}
+
+// NewEngine создаёт движок правил. Если clock равен nil,
+// используется системное время.
+func NewEngine(s storage.Storage, rules []Rule, clock Clock) *Engine {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &Engine{
+		storage: s,
+		rules:   rules,
+		clock:   clock,
+		alerts:  make(map[string]*Alert),
+		samples: make(map[string]sample),
+	}
+}
+
+// SetNotifier подключает рассылку уведомлений. Вызывается до Run.
+func (e *Engine) SetNotifier(n *Notifier) {
+	e.notifier = n
+}
+
+// SetRules заменяет набор правил. Алерты правил, которые остались
+// без изменений, сохраняют состояние; алерты удалённых и изменённых
+// правил снимаются, а сработавшие из них Evaluate вернёт как resolved.
+func (e *Engine) SetRules(rules []Rule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	kept := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		for _, old := range e.rules {
+			if old.Name == r.Name && old.Expr == r.Expr {
+				kept[r.Name] = true
+			}
+		}
+	}
+
+	now := e.clock.Now()
+	for id, a := range e.alerts {
+		if kept[a.Rule] {
+			continue
+		}
+		delete(e.alerts, id)
+		if a.State == StateFiring {
+			a.State = StateResolved
+			a.ResolvedAt = &now
+			e.resolved = append(e.resolved, *a)
+		}
+	}
+	for id := range e.samples {
+		if rule, _, _ := strings.Cut(id, "\x00"); !kept[rule] {
+			delete(e.samples, id)
+		}
+	}
+	e.rules = rules
+}
+
+// Rules возвращает текущий набор правил.
+func (e *Engine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]Rule(nil), e.rules...)
+}
+
+// Run проверяет правила каждые interval до отмены ctx. Уведомления
+// рассылаются в отдельной горутине, чтобы недоступный webhook не
+// задерживал проверку. Пока идёт рассылка, алерты новых проверок
+// копятся и уходят следующей рассылкой, свежие — первыми.
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	queue := make(chan []Alert)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(queue)
+	if e.notifier != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for alerts := range queue {
+				e.notifier.Notify(ctx, alerts)
+			}
+		}()
+	}
+
+	var backlog []Alert
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed := e.Evaluate()
+			if e.notifier == nil {
+				continue
+			}
+			backlog = latest(append(append(changed, e.Alerts()...), backlog...))
+			select {
+			case queue <- backlog:
+				backlog = nil
+			default:
+			}
+		}
+	}
+}
+
+// latest оставляет первое, то есть самое свежее, состояние каждого
+// алерта. Pending-алерты не рассылаются и отбрасываются сразу, чтобы
+// не вытеснить ещё не отправленный resolved.
+func latest(alerts []Alert) []Alert {
+	seen := make(map[string]bool, len(alerts))
+	res := alerts[:0]
+	for _, a := range alerts {
+		if a.State == StatePending {
+			continue
+		}
+		id := alertID(a.Rule, a.Key())
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// Evaluate проверяет все правила и возвращает алерты,
+// сменившие состояние на pending, firing или resolved.
+// Правило проверяется отдельно для каждой серии метрики,
+// метки которой содержат метки из выражения правила.
+func (e *Engine) Evaluate() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.clock.Now()
+	changed := e.resolved
+	e.resolved = nil
+
+	gauges := e.storage.GetAllGauges()
+	counters := e.storage.GetAllCounters()
+
+	for _, r := range e.rules {
+		active := make(map[string]bool)
+
+		values := e.values(r, gauges, counters, now)
+		for key, v := range values {
+			if !r.match(v) {
+				continue
+			}
+			active[key] = true
+
+			id := alertID(r.Name, key)
+			a := e.alerts[id]
+			if a == nil {
+				metricID, labels := models.ParseKey(key)
+				a = &Alert{
+					Rule:       r.Name,
+					Expr:       r.Expr,
+					MetricID:   metricID,
+					MetricType: r.MetricType,
+					Labels:     labels,
+					State:      StatePending,
+					ActiveAt:   now,
+				}
+				e.alerts[id] = a
+				if r.For > 0 {
+					a.Value = v
+					changed = append(changed, *a)
+				}
+			}
+			a.Value = v
+
+			if a.State == StatePending && now.Sub(a.ActiveAt) >= r.For {
+				a.State = StateFiring
+				a.FiredAt = &now
+				changed = append(changed, *a)
+			}
+		}
+
+		for id, a := range e.alerts {
+			if a.Rule != r.Name || active[a.Key()] {
+				continue
+			}
+			delete(e.alerts, id)
+			if a.State == StateFiring {
+				a.State = StateResolved
+				a.Value = values[a.Key()]
+				a.ResolvedAt = &now
+				changed = append(changed, *a)
+			}
+		}
+	}
+
+	sortAlerts(changed)
+	return changed
+}
+
+// Alerts возвращает активные (pending и firing) алерты,
+// отсортированные по имени правила и серии.
+func (e *Engine) Alerts() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	res := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		res = append(res, *a)
+	}
+	sortAlerts(res)
+	return res
+}
+
+// Key возвращает ключ серии, по которой сработал алерт.
+func (a Alert) Key() string {
+	return models.Key(a.MetricID, a.Labels)
+}
+
+func alertID(rule, key string) string {
+	return rule + "\x00" + key
+}
+
+func sortAlerts(alerts []Alert) {
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Key() < alerts[j].Key()
+	})
+}
+
+// values возвращает значения правила по всем подходящим сериям.
+// Для rate-правил серия без предыдущего замера пропускается.
+func (e *Engine) values(r Rule, gauges map[string]float64, counters map[string]int64, now time.Time) map[string]float64 {
+	id, filter := models.ParseKey(r.MetricID)
+	res := make(map[string]float64)
+
+	switch r.MetricType {
+	case models.Gauge:
+		for key, v := range gauges {
+			if matchSeries(key, id, filter) {
+				res[key] = v
+			}
+		}
+	case models.Counter:
+		for key, cur := range counters {
+			if !matchSeries(key, id, filter) {
+				continue
+			}
+			if !r.Rate {
+				res[key] = float64(cur)
+				continue
+			}
+
+			sid := alertID(r.Name, key)
+			prev, seen := e.samples[sid]
+			e.samples[sid] = sample{value: cur, at: now}
+			elapsed := now.Sub(prev.at).Seconds()
+			if !seen || elapsed <= 0 {
+				continue
+			}
+			delta := cur - prev.value
+			if delta < 0 {
+				delta = cur
+			}
+			res[key] = float64(delta) / elapsed
+		}
+	}
+	return res
+}
+
+func matchSeries(key, id string, filter map[string]string) bool {
+	if key == id && len(filter) == 0 {
+		return true
+	}
+	kid, labels := models.ParseKey(key)
+	return kid == id && models.MatchLabels(labels, filter)
+}
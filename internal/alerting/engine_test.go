@@ -0,0 +1,207 @@
+package alerting
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func mustParse(t *testing.T, expr string) Rule {
+	t.Helper()
+	r, err := ParseRule(expr)
+	require.NoError(t, err)
+	return r
+}
+
+func TestEngineGaugeLifecycle(t *testing.T) {
+	store := storage.NewMemStorage()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	engine := NewEngine(store, []Rule{mustParse(t, "gauge HeapAlloc > 500MB for 2m")}, clock)
+
+	assert.Empty(t, engine.Evaluate())
+	assert.Empty(t, engine.Alerts())
+
+	store.SetGauge("HeapAlloc", 600<<20)
+	changed := engine.Evaluate()
+	require.Len(t, changed, 1)
+	assert.Equal(t, StatePending, changed[0].State)
+
+	clock.Advance(time.Minute)
+	assert.Empty(t, engine.Evaluate())
+
+	clock.Advance(time.Minute)
+	changed = engine.Evaluate()
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateFiring, changed[0].State)
+	assert.Equal(t, clock.now, *changed[0].FiredAt)
+
+	alerts := engine.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StateFiring, alerts[0].State)
+	assert.Equal(t, float64(600<<20), alerts[0].Value)
+
+	store.SetGauge("HeapAlloc", 100)
+	clock.Advance(time.Minute)
+	changed = engine.Evaluate()
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateResolved, changed[0].State)
+	assert.Equal(t, clock.now, *changed[0].ResolvedAt)
+	assert.Empty(t, engine.Alerts())
+}
+
+func TestEnginePendingIsDroppedSilently(t *testing.T) {
+	store := storage.NewMemStorage()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	engine := NewEngine(store, []Rule{mustParse(t, "gauge X > 1 for 1m")}, clock)
+
+	store.SetGauge("X", 2)
+	require.Len(t, engine.Evaluate(), 1)
+
+	store.SetGauge("X", 0)
+	clock.Advance(30 * time.Second)
+	assert.Empty(t, engine.Evaluate())
+	assert.Empty(t, engine.Alerts())
+}
+
+func TestEngineCounterRate(t *testing.T) {
+	store := storage.NewMemStorage()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	engine := NewEngine(store, []Rule{mustParse(t, "counter PollCount rate < 1/min")}, clock)
+
+	store.SetCounter("PollCount", 10)
+	assert.Empty(t, engine.Evaluate(), "rate needs two samples")
+
+	clock.Advance(time.Minute)
+	store.SetCounter("PollCount", 5)
+	assert.Empty(t, engine.Evaluate())
+
+	clock.Advance(time.Minute)
+	changed := engine.Evaluate()
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateFiring, changed[0].State)
+	assert.Equal(t, 0.0, changed[0].Value)
+
+	clock.Advance(time.Minute)
+	store.SetCounter("PollCount", 2)
+	changed = engine.Evaluate()
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateResolved, changed[0].State)
+}
+
+func TestEngineEvaluatesEachSeries(t *testing.T) {
+	store := storage.NewMemStorage()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	engine := NewEngine(store, []Rule{
+		mustParse(t, "gauge HeapAlloc > 100"),
+		mustParse(t, `gauge HeapAlloc{host="b"} > 100`),
+	}, clock)
+
+	a := models.Key("HeapAlloc", map[string]string{"host": "a"})
+	b := models.Key("HeapAlloc", map[string]string{"host": "b"})
+	store.SetGauge(a, 200)
+	store.SetGauge(b, 300)
+	store.SetGauge("HeapAlloc", 50)
+
+	changed := engine.Evaluate()
+	require.Len(t, changed, 3)
+	assert.Equal(t, "gauge HeapAlloc > 100", changed[0].Rule)
+	assert.Equal(t, map[string]string{"host": "a"}, changed[0].Labels)
+	assert.Equal(t, 200.0, changed[0].Value)
+	assert.Equal(t, map[string]string{"host": "b"}, changed[1].Labels)
+	assert.Equal(t, `gauge HeapAlloc{host="b"} > 100`, changed[2].Rule)
+	assert.Equal(t, "HeapAlloc", changed[2].MetricID)
+
+	store.SetGauge(a, 10)
+	clock.Advance(time.Minute)
+	changed = engine.Evaluate()
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateResolved, changed[0].State)
+	assert.Equal(t, a, changed[0].Key())
+	assert.Len(t, engine.Alerts(), 2)
+}
+
+func TestEngineSetRules(t *testing.T) {
+	store := storage.NewMemStorage()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	keep := mustParse(t, "gauge X > 1")
+	drop := mustParse(t, "gauge Y > 1")
+	engine := NewEngine(store, []Rule{keep, drop}, clock)
+
+	store.SetGauge("X", 2)
+	store.SetGauge("Y", 2)
+	require.Len(t, engine.Evaluate(), 2)
+	firedAt := *engine.Alerts()[0].FiredAt
+
+	clock.Advance(time.Minute)
+	engine.SetRules([]Rule{keep, mustParse(t, "gauge Y > 10 for 1m")})
+	assert.Len(t, engine.Rules(), 2)
+
+	alerts := engine.Alerts()
+	require.Len(t, alerts, 1, "alerts of changed rules are dropped")
+	assert.Equal(t, keep.Name, alerts[0].Rule)
+	assert.Equal(t, firedAt, *alerts[0].FiredAt, "unchanged rule keeps its state")
+
+	changed := engine.Evaluate()
+	require.Len(t, changed, 1)
+	assert.Equal(t, drop.Name, changed[0].Rule)
+	assert.Equal(t, StateResolved, changed[0].State)
+	assert.Equal(t, clock.now, *changed[0].ResolvedAt)
+	assert.Empty(t, engine.Evaluate())
+}
+
+type countingClock struct {
+	calls atomic.Int64
+}
+
+func (c *countingClock) Now() time.Time {
+	c.calls.Add(1)
+	return time.Now()
+}
+
+func TestEngineRunDoesNotWaitForWebhook(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+	defer srv.Close()
+
+	store := storage.NewMemStorage()
+	store.SetGauge("HeapAlloc", 2048)
+	clock := &countingClock{}
+	engine := NewEngine(store, []Rule{mustParse(t, "gauge HeapAlloc > 1KB")}, clock)
+	engine.SetNotifier(NewNotifier(store, []string{srv.URL}, time.Hour, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		engine.Run(ctx, 5*time.Millisecond)
+	}()
+
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	evaluated := clock.calls.Load()
+	assert.Eventually(t, func() bool { return clock.calls.Load() >= evaluated+5 }, time.Second, time.Millisecond,
+		"evaluation goes on while the webhook hangs")
+
+	cancel()
+	close(release)
+	<-done
+}
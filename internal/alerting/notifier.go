@@ -0,0 +1,264 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+)
+
+const (
+	defaultRetries    = 3
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = 30 * time.Second
+)
+
+type Payload struct {
+	Rule       string            `json:"rule"`
+	Expr       string            `json:"expr"`
+	MetricID   string            `json:"id"`
+	MetricType string            `json:"type"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	State      State             `json:"state"`
+	Value      *float64          `json:"value,omitempty"`
+	EvalValue  float64           `json:"eval_value"`
+	ActiveAt   time.Time         `json:"active_at"`
+	FiredAt    *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time        `json:"resolved_at,omitempty"`
+	SentAt     time.Time         `json:"sent_at"`
+}
+
+type delivery struct {
+	state State
+	at    time.Time
+}
+
+// target — алерт на конкретном webhook'е.
+type target struct {
+	alert string
+	url   string
+}
+
+// Notifier рассылает firing и resolved алерты на webhook'и.
+// Повторное уведомление с тем же состоянием отправляется
+// не чаще, чем раз в repeatInterval. Доставка учитывается отдельно
+// для каждого адреса; алерт, не доставленный хотя бы на один адрес,
+// хранится в pending и отправляется снова при следующем Notify,
+// даже если движок его больше не возвращает.
+type Notifier struct {
+	storage        storage.Storage
+	urls           []string
+	repeatInterval time.Duration
+	clock          Clock
+	client         *http.Client
+
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mu      sync.Mutex
+	sent    map[target]delivery
+	pending map[string]Alert
+}
+
+func NewNotifier(s storage.Storage, urls []string, repeatInterval time.Duration, clock Clock) *Notifier {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &Notifier{
+		storage:        s,
+		urls:           urls,
+		repeatInterval: repeatInterval,
+		clock:          clock,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+		maxBackoff: defaultMaxBackoff,
+		sent:       make(map[target]delivery),
+		pending:    make(map[string]Alert),
+	}
+}
+
+// Notify отправляет алерты, прошедшие дедупликацию, вместе с ещё
+// не доставленными алертами прошлых вызовов. Pending-алерты
+// пропускаются. Алерт отправляется на каждый адрес, куда его текущее
+// состояние ещё не доставлено; неудачные адреса получат его при
+// следующем вызове. После доставки resolved-алерта на все адреса
+// сведения о нём забываются.
+func (n *Notifier) Notify(ctx context.Context, alerts []Alert) {
+	for _, a := range n.merge(alerts) {
+		id := alertID(a.Rule, a.Key())
+		p := n.payload(a)
+		done := true
+		for _, url := range n.urls {
+			if !n.due(a, url) {
+				continue
+			}
+			if err := n.deliver(ctx, url, p); err != nil {
+				log.Printf("alerting: notify %s about %s %s: %v", url, a.Rule, a.Key(), err)
+				done = false
+				continue
+			}
+			n.mu.Lock()
+			n.sent[target{id, url}] = delivery{state: a.State, at: p.SentAt}
+			n.mu.Unlock()
+		}
+
+		n.mu.Lock()
+		switch {
+		case !done:
+			n.pending[id] = a
+		case a.State == StateResolved:
+			delete(n.pending, id)
+			for _, url := range n.urls {
+				delete(n.sent, target{id, url})
+			}
+		default:
+			delete(n.pending, id)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// merge дополняет alerts недоставленными алертами прошлых вызовов.
+// Свежее состояние алерта заменяет недоставленное.
+func (n *Notifier) merge(alerts []Alert) []Alert {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	res := make([]Alert, 0, len(alerts)+len(n.pending))
+	seen := make(map[string]bool, len(alerts))
+	for _, a := range alerts {
+		if a.State == StatePending {
+			continue
+		}
+		id := alertID(a.Rule, a.Key())
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		res = append(res, a)
+	}
+
+	ids := make([]string, 0, len(n.pending))
+	for id := range n.pending {
+		if !seen[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		res = append(res, n.pending[id])
+	}
+	return res
+}
+
+func (n *Notifier) due(a Alert, url string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	last, ok := n.sent[target{alertID(a.Rule, a.Key()), url}]
+	if !ok || last.state != a.State {
+		return true
+	}
+	return a.State == StateFiring && n.repeatInterval > 0 &&
+		n.clock.Now().Sub(last.at) >= n.repeatInterval
+}
+
+func (n *Notifier) payload(a Alert) Payload {
+	p := Payload{
+		Rule:       a.Rule,
+		Expr:       a.Expr,
+		MetricID:   a.MetricID,
+		MetricType: a.MetricType,
+		Labels:     a.Labels,
+		State:      a.State,
+		EvalValue:  a.Value,
+		ActiveAt:   a.ActiveAt,
+		FiredAt:    a.FiredAt,
+		ResolvedAt: a.ResolvedAt,
+		SentAt:     n.clock.Now(),
+	}
+
+	switch a.MetricType {
+	case models.Gauge:
+		if v, ok := n.storage.GetGauge(a.Key()); ok {
+			p.Value = &v
+		}
+	case models.Counter:
+		if v, ok := n.storage.GetCounter(a.Key()); ok {
+			f := float64(v)
+			p.Value = &f
+		}
+	}
+	return p
+}
+
+type retriableError struct {
+	err error
+}
+
+func (e retriableError) Error() string { return e.err.Error() }
+func (e retriableError) Unwrap() error { return e.err }
+
+func (n *Notifier) deliver(ctx context.Context, url string, p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	wait := n.backoff
+	for attempt := 0; ; attempt++ {
+		err = n.post(ctx, url, body)
+
+		var re retriableError
+		if err == nil || !errors.As(err, &re) || attempt >= n.retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if wait > n.maxBackoff {
+			wait = n.maxBackoff
+		}
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return retriableError{err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return retriableError{fmt.Errorf("webhook returned status: %d", resp.StatusCode)}
+	default:
+		return fmt.Errorf("webhook returned status: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,204 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type webhookStub struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	calls    int
+	payloads []Payload
+}
+
+func (s *webhookStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(s.status)
+		return
+	}
+
+	var p Payload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.payloads = append(s.payloads, p)
+	w.WriteHeader(http.StatusOK)
+}
+
+func newTestNotifier(s storage.Storage, url string, clock Clock) *Notifier {
+	n := NewNotifier(s, []string{url}, time.Hour, clock)
+	n.backoff = time.Millisecond
+	n.maxBackoff = 4 * time.Millisecond
+	return n
+}
+
+func firingAlert(clock *fakeClock) Alert {
+	now := clock.now
+	return Alert{
+		Rule:       "HighHeap",
+		Expr:       "gauge HeapAlloc > 1KB",
+		MetricID:   "HeapAlloc",
+		MetricType: "gauge",
+		State:      StateFiring,
+		Value:      2048,
+		ActiveAt:   now,
+		FiredAt:    &now,
+	}
+}
+
+func TestNotifierPayload(t *testing.T) {
+	stub := &webhookStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	store := storage.NewMemStorage()
+	store.SetGauge("HeapAlloc", 4096)
+	clock := &fakeClock{now: time.Unix(1000, 0).UTC()}
+
+	n := newTestNotifier(store, srv.URL, clock)
+	n.Notify(context.Background(), []Alert{firingAlert(clock)})
+
+	require.Len(t, stub.payloads, 1)
+	p := stub.payloads[0]
+	assert.Equal(t, "HighHeap", p.Rule)
+	assert.Equal(t, "HeapAlloc", p.MetricID)
+	assert.Equal(t, "gauge", p.MetricType)
+	assert.Equal(t, StateFiring, p.State)
+	require.NotNil(t, p.Value)
+	assert.Equal(t, 4096.0, *p.Value)
+	assert.Equal(t, 2048.0, p.EvalValue)
+	assert.True(t, clock.now.Equal(p.SentAt))
+}
+
+func TestNotifierDedupAndRepeat(t *testing.T) {
+	stub := &webhookStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	store := storage.NewMemStorage()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	n := newTestNotifier(store, srv.URL, clock)
+
+	a := firingAlert(clock)
+	pending := a
+	pending.State = StatePending
+
+	n.Notify(context.Background(), []Alert{pending})
+	assert.Equal(t, 0, stub.calls)
+
+	n.Notify(context.Background(), []Alert{a, a})
+	assert.Equal(t, 1, stub.calls)
+
+	clock.Advance(30 * time.Minute)
+	n.Notify(context.Background(), []Alert{a})
+	assert.Equal(t, 1, stub.calls)
+
+	clock.Advance(30 * time.Minute)
+	n.Notify(context.Background(), []Alert{a})
+	assert.Equal(t, 2, stub.calls)
+
+	resolved := a
+	resolved.State = StateResolved
+	n.Notify(context.Background(), []Alert{resolved})
+	assert.Equal(t, 3, stub.calls)
+	assert.Equal(t, StateResolved, stub.payloads[2].State)
+	assert.Empty(t, n.sent, "delivered resolved alerts are forgotten")
+	assert.Empty(t, n.pending)
+}
+
+func TestNotifierRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		failures  int
+		calls     int
+		delivered bool
+	}{
+		{"recovers after 5xx", http.StatusServiceUnavailable, 2, 3, true},
+		{"gives up after retries", http.StatusInternalServerError, 10, defaultRetries + 1, false},
+		{"no retry on 4xx", http.StatusBadRequest, 10, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &webhookStub{status: tt.status, failures: tt.failures}
+			srv := httptest.NewServer(stub)
+			defer srv.Close()
+
+			clock := &fakeClock{now: time.Unix(1000, 0)}
+			n := newTestNotifier(storage.NewMemStorage(), srv.URL, clock)
+			n.Notify(context.Background(), []Alert{firingAlert(clock)})
+
+			assert.Equal(t, tt.calls, stub.calls)
+			assert.Equal(t, tt.delivered, len(stub.payloads) == 1)
+			assert.Equal(t, !tt.delivered, n.due(firingAlert(clock), srv.URL))
+		})
+	}
+}
+
+func TestNotifierRetriesResolvedOnNextCall(t *testing.T) {
+	stub := &webhookStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	n := newTestNotifier(storage.NewMemStorage(), srv.URL, clock)
+	n.retries = 0
+
+	a := firingAlert(clock)
+	n.Notify(context.Background(), []Alert{a})
+	require.Len(t, stub.payloads, 1)
+
+	stub.status, stub.failures = http.StatusInternalServerError, 1
+	resolved := a
+	resolved.State = StateResolved
+	n.Notify(context.Background(), []Alert{resolved})
+	require.Len(t, stub.payloads, 1, "resolved delivery failed")
+
+	// Движок возвращает resolved-алерт только один раз.
+	n.Notify(context.Background(), nil)
+	require.Len(t, stub.payloads, 2)
+	assert.Equal(t, StateResolved, stub.payloads[1].State)
+	assert.Empty(t, n.pending)
+	assert.Empty(t, n.sent)
+
+	n.Notify(context.Background(), nil)
+	assert.Len(t, stub.payloads, 2)
+}
+
+func TestNotifierTracksDeliveryPerURL(t *testing.T) {
+	ok, failing := &webhookStub{}, &webhookStub{status: http.StatusInternalServerError, failures: 1}
+	okSrv, failingSrv := httptest.NewServer(ok), httptest.NewServer(failing)
+	defer okSrv.Close()
+	defer failingSrv.Close()
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	n := NewNotifier(storage.NewMemStorage(), []string{okSrv.URL, failingSrv.URL}, time.Hour, clock)
+	n.retries = 0
+
+	a := firingAlert(clock)
+	n.Notify(context.Background(), []Alert{a})
+	assert.Len(t, ok.payloads, 1)
+	assert.Empty(t, failing.payloads)
+
+	n.Notify(context.Background(), []Alert{a})
+	assert.Len(t, ok.payloads, 1, "delivered webhook is not notified again")
+	assert.Len(t, failing.payloads, 1, "failed webhook gets the alert on the next call")
+}
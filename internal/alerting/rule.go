@@ -0,0 +1,240 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+type Op string
+
+const (
+	OpGT Op = ">"
+	OpGE Op = ">="
+	OpLT Op = "<"
+	OpLE Op = "<="
+	OpEQ Op = "=="
+	OpNE Op = "!="
+)
+
+// Rule описывает условие вида
+//
+//	gauge HeapAlloc > 500MB for 2m
+//	counter PollCount rate < 1/min
+//	gauge HeapAlloc{host="web-1"} > 500MB
+//
+// Метки после имени метрики ограничивают набор проверяемых серий.
+// Для rate порог хранится в единицах в секунду.
+type Rule struct {
+	Name       string
+	Expr       string
+	MetricType string
+	MetricID   string
+	Rate       bool
+	Op         Op
+	Threshold  float64
+	For        time.Duration
+}
+
+type ruleSpec struct {
+	Name string `json:"name" yaml:"name"`
+	Expr string `json:"expr" yaml:"expr"`
+}
+
+type rulesFile struct {
+	Rules []ruleSpec `json:"rules" yaml:"rules"`
+}
+
+// LoadRules читает правила из YAML- или JSON-файла.
+// Формат выбирается по расширению, по умолчанию — YAML.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f rulesFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &f)
+	default:
+		err = yaml.Unmarshal(data, &f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	rules := make([]Rule, 0, len(f.Rules))
+	seen := make(map[string]bool, len(f.Rules))
+	for _, spec := range f.Rules {
+		r, err := ParseRule(spec.Expr)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Name != "" {
+			r.Name = spec.Name
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("%w: duplicate name %q", ErrInvalidRule, r.Name)
+		}
+		seen[r.Name] = true
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// ParseRule разбирает выражение правила. Имя правила по умолчанию
+// совпадает с выражением.
+func ParseRule(expr string) (Rule, error) {
+	fields := strings.Fields(expr)
+	r := Rule{
+		Name: strings.Join(fields, " "),
+		Expr: strings.Join(fields, " "),
+	}
+
+	if len(fields) < 4 {
+		return Rule{}, fmt.Errorf("%w: %q", ErrInvalidRule, expr)
+	}
+
+	r.MetricType, r.MetricID = fields[0], fields[1]
+	switch r.MetricType {
+	case models.Gauge, models.Counter:
+	default:
+		return Rule{}, fmt.Errorf("%w: unknown metric type %q", ErrInvalidRule, r.MetricType)
+	}
+	if id, labels := models.ParseKey(r.MetricID); labels != nil {
+		r.MetricID = models.Key(id, labels)
+	} else if strings.ContainsAny(r.MetricID, "{}") {
+		return Rule{}, fmt.Errorf("%w: bad labels in %q", ErrInvalidRule, r.MetricID)
+	}
+	fields = fields[2:]
+
+	if fields[0] == "rate" {
+		if r.MetricType != models.Counter {
+			return Rule{}, fmt.Errorf("%w: rate is only supported for counters", ErrInvalidRule)
+		}
+		r.Rate = true
+		fields = fields[1:]
+	}
+
+	if len(fields) != 2 && len(fields) != 4 {
+		return Rule{}, fmt.Errorf("%w: %q", ErrInvalidRule, expr)
+	}
+
+	switch op := Op(fields[0]); op {
+	case OpGT, OpGE, OpLT, OpLE, OpEQ, OpNE:
+		r.Op = op
+	default:
+		return Rule{}, fmt.Errorf("%w: unknown operator %q", ErrInvalidRule, fields[0])
+	}
+
+	var err error
+	if r.Rate {
+		r.Threshold, err = parseRate(fields[1])
+	} else {
+		r.Threshold, err = parseValue(fields[1])
+	}
+	if err != nil {
+		return Rule{}, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+
+	if len(fields) == 4 {
+		if fields[2] != "for" {
+			return Rule{}, fmt.Errorf("%w: expected \"for\", got %q", ErrInvalidRule, fields[2])
+		}
+		r.For, err = time.ParseDuration(fields[3])
+		if err != nil || r.For < 0 {
+			return Rule{}, fmt.Errorf("%w: bad duration %q", ErrInvalidRule, fields[3])
+		}
+	}
+
+	return r, nil
+}
+
+func (r Rule) match(v float64) bool {
+	switch r.Op {
+	case OpGT:
+		return v > r.Threshold
+	case OpGE:
+		return v >= r.Threshold
+	case OpLT:
+		return v < r.Threshold
+	case OpLE:
+		return v <= r.Threshold
+	case OpEQ:
+		return v == r.Threshold
+	case OpNE:
+		return v != r.Threshold
+	}
+	return false
+}
+
+var sizeUnits = []struct {
+	suffix string
+	mult   float64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"TB", 1 << 40},
+	{"B", 1},
+}
+
+// parseValue понимает числа с необязательным суффиксом размера
+// (B, KB, MB, GB, TB; множитель 1024).
+func parseValue(s string) (float64, error) {
+	mult := 1.0
+	upper := strings.ToUpper(s)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			mult = u.mult
+			s = s[:len(s)-len(u.suffix)]
+			break
+		}
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	return v * mult, nil
+}
+
+var rateUnits = map[string]time.Duration{
+	"s":   time.Second,
+	"sec": time.Second,
+	"m":   time.Minute,
+	"min": time.Minute,
+	"h":   time.Hour,
+}
+
+// parseRate разбирает значения вида 1/min и возвращает их в единицах
+// в секунду.
+func parseRate(s string) (float64, error) {
+	num, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("bad rate %q", s)
+	}
+
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad rate %q", s)
+	}
+
+	per, ok := rateUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("bad rate unit %q", unit)
+	}
+	return v / per.Seconds(), nil
+}
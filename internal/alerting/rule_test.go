@@ -0,0 +1,101 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected Rule
+		wantErr  bool
+	}{
+		{
+			name: "gauge with size and duration",
+			expr: "gauge HeapAlloc > 500MB for 2m",
+			expected: Rule{
+				MetricType: "gauge", MetricID: "HeapAlloc",
+				Op: OpGT, Threshold: 500 << 20, For: 2 * time.Minute,
+			},
+		},
+		{
+			name: "counter rate",
+			expr: "counter PollCount rate < 1/min",
+			expected: Rule{
+				MetricType: "counter", MetricID: "PollCount", Rate: true,
+				Op: OpLT, Threshold: 1.0 / 60,
+			},
+		},
+		{
+			name: "plain counter",
+			expr: "counter PollCount >= 10",
+			expected: Rule{
+				MetricType: "counter", MetricID: "PollCount",
+				Op: OpGE, Threshold: 10,
+			},
+		},
+		{
+			name: "gauge with labels",
+			expr: `gauge HeapAlloc{instance="b",host="a"} > 1GB`,
+			expected: Rule{
+				MetricType: "gauge", MetricID: `HeapAlloc{host="a",instance="b"}`,
+				Op: OpGT, Threshold: 1 << 30,
+			},
+		},
+		{name: "bad labels", expr: `gauge X{host=a} > 1`, wantErr: true},
+		{name: "rate on gauge", expr: "gauge Alloc rate > 1/s", wantErr: true},
+		{name: "unknown type", expr: "histogram X > 1", wantErr: true},
+		{name: "unknown op", expr: "gauge X ~ 1", wantErr: true},
+		{name: "bad value", expr: "gauge X > abc", wantErr: true},
+		{name: "bad duration", expr: "gauge X > 1 for soon", wantErr: true},
+		{name: "too short", expr: "gauge X >", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRule(tt.expr)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidRule)
+				return
+			}
+			require.NoError(t, err)
+
+			tt.expected.Name = tt.expr
+			tt.expected.Expr = tt.expr
+			assert.Equal(t, tt.expected, r)
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "rules.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`
+rules:
+  - name: HighHeap
+    expr: gauge HeapAlloc > 500MB for 2m
+  - expr: counter PollCount rate < 1/min
+`), 0o644))
+
+	rules, err := LoadRules(yamlPath)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "HighHeap", rules[0].Name)
+	assert.Equal(t, "counter PollCount rate < 1/min", rules[1].Name)
+
+	jsonPath := filepath.Join(dir, "rules.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(
+		`{"rules":[{"name":"a","expr":"gauge X > 1"},{"name":"a","expr":"gauge Y > 1"}]}`,
+	), 0o644))
+
+	_, err = LoadRules(jsonPath)
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
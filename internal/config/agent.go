@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+// Agent — настройки cmd/agent.
+type Agent struct {
+	Address        string    `json:"address" yaml:"address"`
+	ReportInterval Duration  `json:"report_interval" yaml:"report_interval"`
+	PollInterval   Duration  `json:"poll_interval" yaml:"poll_interval"`
+	Key            string    `json:"key" yaml:"key"`
+	RateLimit      int       `json:"rate_limit" yaml:"rate_limit"`
+	Instance       string    `json:"instance" yaml:"instance"`
+	GCPauseBuckets []float64 `json:"gc_pause_buckets" yaml:"gc_pause_buckets"`
+	Transport      string    `json:"transport" yaml:"transport"`
+	GRPCAddress    string    `json:"grpc_address" yaml:"grpc_address"`
+	CryptoKey      string    `json:"crypto_key" yaml:"crypto_key"`
+}
+
+// DefaultAgent возвращает настройки агента по умолчанию.
+func DefaultAgent() Agent {
+	return Agent{
+		Address:        "localhost:8080",
+		ReportInterval: Seconds(10),
+		PollInterval:   Seconds(2),
+		RateLimit:      1,
+		Transport:      "http",
+		GRPCAddress:    "localhost:3200",
+	}
+}
+
+func (c *Agent) options() []option {
+	return []option{
+		{"a", "ADDRESS", "Server address (host:port)", stringValue{&c.Address}},
+		{"r", "REPORT_INTERVAL", "Report interval (10s or seconds)", &c.ReportInterval},
+		{"p", "POLL_INTERVAL", "Poll interval (2s or seconds)", &c.PollInterval},
+		{"k", "KEY", "Key for HMAC-SHA256 signing", stringValue{&c.Key}},
+		{"l", "RATE_LIMIT", "Max concurrent requests to the server", intValue{&c.RateLimit}},
+		{"instance", "INSTANCE", "Value of the instance label (defaults to hostname)", stringValue{&c.Instance}},
+		{"gc-buckets", "GC_PAUSE_BUCKETS", "Comma-separated GC pause histogram bucket bounds in seconds", floatsValue{&c.GCPauseBuckets}},
+		{"transport", "TRANSPORT", "Transport for sending metrics: http or grpc", stringValue{&c.Transport}},
+		{"g", "GRPC_ADDRESS", "gRPC server address (host:port)", stringValue{&c.GRPCAddress}},
+		{"crypto-key", "CRYPTO_KEY", "Path to server RSA public key (PEM) for encrypting payloads", stringValue{&c.CryptoKey}},
+	}
+}
+
+// LoadAgent собирает настройки агента из аргументов командной строки
+// args, окружения env и файла, заданного -c или CONFIG.
+func LoadAgent(args []string, env LookupEnv) (Agent, error) {
+	c := DefaultAgent()
+	if err := load("agent", &c, c.options(), args, env); err != nil {
+		return Agent{}, err
+	}
+	if err := c.Validate(); err != nil {
+		return Agent{}, err
+	}
+	return c, nil
+}
+
+// ServerURL возвращает адрес HTTP-сервера со схемой.
+func (c Agent) ServerURL() string {
+	if strings.HasPrefix(c.Address, "http://") || strings.HasPrefix(c.Address, "https://") {
+		return c.Address
+	}
+	return "http://" + c.Address
+}
+
+// Validate проверяет согласованность настроек.
+func (c Agent) Validate() error {
+	var errs []error
+	addr := strings.TrimPrefix(strings.TrimPrefix(c.Address, "http://"), "https://")
+	if err := validAddress(strings.TrimSuffix(addr, "/")); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ReportInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("report interval must be positive, got %s", c.ReportInterval))
+	}
+	if c.PollInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("poll interval must be positive, got %s", c.PollInterval))
+	}
+	if c.RateLimit <= 0 {
+		errs = append(errs, fmt.Errorf("rate limit must be positive, got %d", c.RateLimit))
+	}
+	if len(c.GCPauseBuckets) > 0 {
+		if err := models.NewHistogram(c.GCPauseBuckets).Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid GC pause buckets: %w", err))
+		}
+	}
+	switch c.Transport {
+	case "http":
+	case "grpc":
+		if err := validAddress(c.GRPCAddress); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown transport %q, want http or grpc", c.Transport))
+	}
+	if c.CryptoKey != "" && c.Transport != "http" {
+		errs = append(errs, errors.New("crypto key is only supported with http transport"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,295 @@
+// Package config собирает настройки сервера и агента из значений
+// по умолчанию, файла конфигурации, флагов и переменных окружения.
+// Приоритет источников: окружение > флаги > файл > значения по умолчанию.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LookupEnv — источник переменных окружения, обычно os.LookupEnv.
+type LookupEnv func(string) (string, bool)
+
+// Duration — интервал, который в файле, флагах и окружении задаётся
+// строкой вида "10s" или числом секунд.
+type Duration struct {
+	time.Duration
+}
+
+// Seconds возвращает Duration из числа секунд.
+func Seconds(n int) Duration {
+	return Duration{time.Duration(n) * time.Second}
+}
+
+func (d *Duration) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		d.Duration = time.Duration(n) * time.Second
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return d.Set(s)
+	}
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	d.Duration = time.Duration(n) * time.Second
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: duration must be a scalar", node.Line)
+	}
+	return d.Set(node.Value)
+}
+
+// option связывает поле конфигурации с флагом и переменной окружения.
+type option struct {
+	flag  string
+	env   string
+	usage string
+	value flag.Value
+}
+
+// load заполняет cfg, в котором уже записаны значения по умолчанию.
+// Значения флагов откладываются до чтения файла, чтобы файл
+// не перекрывал их.
+func load(name string, cfg any, opts []option, args []string, env LookupEnv) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFlag := fs.String("c", "", "Path to JSON or YAML config file")
+	pending := make([]*deferred, len(opts))
+	for i, o := range opts {
+		pending[i] = &deferred{target: o.value}
+		fs.Var(pending[i], o.flag, o.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := *configFlag
+	if v, ok := lookup(env, "CONFIG"); ok {
+		path = v
+	}
+	if path != "" {
+		if err := loadFile(path, cfg); err != nil {
+			return err
+		}
+	}
+
+	for i, o := range opts {
+		if p := pending[i]; p.isSet {
+			if err := o.value.Set(p.raw); err != nil {
+				return fmt.Errorf("flag -%s: %w", o.flag, err)
+			}
+		}
+		if v, ok := lookup(env, o.env); ok {
+			if err := o.value.Set(v); err != nil {
+				return fmt.Errorf("env %s: %w", o.env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// lookup возвращает непустое значение переменной окружения.
+func lookup(env LookupEnv, key string) (string, bool) {
+	if env == nil {
+		return "", false
+	}
+	v, ok := env(key)
+	if !ok {
+		return "", false
+	}
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", false
+	}
+	return v, true
+}
+
+// loadFile читает YAML- или JSON-файл поверх cfg. Формат выбирается
+// по расширению, по умолчанию — YAML. Неизвестные ключи — ошибка.
+func loadFile(path string, cfg any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+	default:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(cfg)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// deferred запоминает значение флага, не применяя его сразу.
+type deferred struct {
+	target flag.Value
+	raw    string
+	isSet  bool
+}
+
+func (d *deferred) String() string {
+	if d.target == nil {
+		return ""
+	}
+	return d.target.String()
+}
+
+func (d *deferred) Set(v string) error {
+	d.raw = v
+	d.isSet = true
+	return nil
+}
+
+// IsBoolFlag разрешает писать логические флаги без значения: -r.
+func (d *deferred) IsBoolFlag() bool {
+	_, ok := d.target.(boolValue)
+	return ok
+}
+
+type stringValue struct{ p *string }
+
+func (s stringValue) String() string {
+	if s.p == nil {
+		return ""
+	}
+	return *s.p
+}
+func (s stringValue) Set(v string) error {
+	*s.p = v
+	return nil
+}
+
+type intValue struct{ p *int }
+
+func (i intValue) String() string {
+	if i.p == nil {
+		return "0"
+	}
+	return strconv.Itoa(*i.p)
+}
+func (i intValue) Set(v string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", v)
+	}
+	*i.p = n
+	return nil
+}
+
+type boolValue struct{ p *bool }
+
+func (b boolValue) String() string {
+	if b.p == nil {
+		return "false"
+	}
+	return strconv.FormatBool(*b.p)
+}
+func (b boolValue) Set(v string) error {
+	x, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", v)
+	}
+	*b.p = x
+	return nil
+}
+
+// listValue — список строк через запятую.
+type listValue struct{ p *[]string }
+
+func (l listValue) String() string {
+	if l.p == nil {
+		return ""
+	}
+	return strings.Join(*l.p, ",")
+}
+func (l listValue) Set(v string) error {
+	*l.p = splitList(v)
+	return nil
+}
+
+// floatsValue — список чисел через запятую.
+type floatsValue struct{ p *[]float64 }
+
+func (f floatsValue) String() string {
+	if f.p == nil {
+		return ""
+	}
+	parts := make([]string, len(*f.p))
+	for i, v := range *f.p {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+func (f floatsValue) Set(v string) error {
+	var res []float64
+	for _, part := range splitList(v) {
+		x, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", part)
+		}
+		res = append(res, x)
+	}
+	*f.p = res
+	return nil
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// validAddress проверяет адрес вида host:port.
+func validAddress(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid address %q: bad port", addr)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envMap(m map[string]string) LookupEnv {
+	return func(key string) (string, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	return path
+}
+
+func TestDurationSet(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"10", 10 * time.Second, false},
+		{"10s", 10 * time.Second, false},
+		{"1m30s", 90 * time.Second, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{" 0 ", 0, false},
+		{"ten", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var d Duration
+			err := d.Set(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, d.Duration)
+		})
+	}
+}
+
+func TestLoadServerPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "server.yaml", `
+address: "file:1"
+store_interval: 30s
+restore: false
+alert_webhooks: ["http://a", "http://b"]
+`)
+	jsonFile := writeFile(t, "server.json", `{"address": "json:1", "store_interval": 45, "database_dsn": "postgres://x"}`)
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(t *testing.T, c Server)
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, DefaultServer(), c)
+			},
+		},
+		{
+			name: "yaml file overrides defaults",
+			args: []string{"-c", yamlFile},
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, "file:1", c.Address)
+				assert.Equal(t, 30*time.Second, c.StoreInterval.Duration)
+				assert.False(t, c.Restore)
+				assert.Equal(t, []string{"http://a", "http://b"}, c.AlertWebhooks)
+				assert.Equal(t, "metrics-db.json", c.FileStoragePath)
+				assert.Equal(t, "info", c.LogLevel)
+				assert.Empty(t, c.StorageDSN())
+			},
+		},
+		{
+			name: "json file with bare seconds",
+			env:  map[string]string{"CONFIG": jsonFile},
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, "json:1", c.Address)
+				assert.Equal(t, 45*time.Second, c.StoreInterval.Duration)
+				assert.Equal(t, "postgres://x", c.DatabaseDSN)
+				assert.Equal(t, "postgres://x", c.StorageDSN())
+			},
+		},
+		{
+			name: "flags override file",
+			args: []string{"-c", yamlFile, "-a", "flag:1", "-r=true", "-i", "5"},
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, "flag:1", c.Address)
+				assert.True(t, c.Restore)
+				assert.Equal(t, 5*time.Second, c.StoreInterval.Duration)
+			},
+		},
+		{
+			name: "env overrides flags and file",
+			args: []string{"-c", yamlFile, "-a", "flag:1", "-i", "5"},
+			env:  map[string]string{"ADDRESS": "env:1", "STORE_INTERVAL": "1m", "ALERT_WEBHOOKS": "http://c"},
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, "env:1", c.Address)
+				assert.Equal(t, time.Minute, c.StoreInterval.Duration)
+				assert.Equal(t, []string{"http://c"}, c.AlertWebhooks)
+			},
+		},
+		{
+			name: "CONFIG env overrides -c",
+			args: []string{"-c", yamlFile},
+			env:  map[string]string{"CONFIG": jsonFile},
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, "json:1", c.Address)
+			},
+		},
+		{
+			name: "storage url overrides database dsn",
+			env:  map[string]string{"CONFIG": jsonFile, "STORAGE": "mem://"},
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, "mem://", c.StorageDSN())
+			},
+		},
+		{
+			name: "import into bolt storage",
+			env:  map[string]string{"STORAGE": "bolt:metrics.db", "IMPORT_FILE": "metrics-db.json"},
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, "bolt:metrics.db", c.StorageDSN())
+				assert.Equal(t, "metrics-db.json", c.ImportFile)
+			},
+		},
+		{
+			name: "bare bool flag",
+			args: []string{"-c", yamlFile, "-r", "-restore-recover"},
+			check: func(t *testing.T, c Server) {
+				assert.True(t, c.Restore)
+				assert.True(t, c.RestoreRecover)
+				assert.Equal(t, 3, c.SnapshotBackups)
+			},
+		},
+		{
+			name: "empty env is ignored",
+			args: []string{"-a", "flag:1"},
+			env:  map[string]string{"ADDRESS": "  "},
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, "flag:1", c.Address)
+			},
+		},
+		{
+			name: "empty grpc address disables grpc",
+			args: []string{"-g", ""},
+			check: func(t *testing.T, c Server) {
+				assert.Empty(t, c.GRPCAddress)
+			},
+		},
+		{
+			name: "file path with space and colon",
+			args: []string{"-f", "my dir/a:b.json"},
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, "my dir/a:b.json", c.FileStoragePath)
+				assert.Empty(t, c.StorageDSN(), "plain path is not turned into a url")
+			},
+		},
+		{
+			name: "crypto key without grpc",
+			args: []string{"-crypto-key", "priv.pem", "-g", ""},
+			check: func(t *testing.T, c Server) {
+				assert.Equal(t, "priv.pem", c.CryptoKey)
+			},
+		},
+		{name: "crypto key with grpc", args: []string{"-crypto-key", "priv.pem"}, wantErr: true},
+		{name: "bad env duration", env: map[string]string{"STORE_INTERVAL": "soon"}, wantErr: true},
+		{name: "bad flag bool", args: []string{"-r=maybe"}, wantErr: true},
+		{name: "missing file", args: []string{"-c", filepath.Join(t.TempDir(), "none.yaml")}, wantErr: true},
+		{name: "unknown key in file", args: []string{"-c", writeFile(t, "bad.json", `{"adress": "x:1"}`)}, wantErr: true},
+		{name: "invalid address", args: []string{"-a", "localhost"}, wantErr: true},
+		{name: "negative store interval", args: []string{"-i", "-1"}, wantErr: true},
+		{name: "negative snapshot backups", env: map[string]string{"SNAPSHOT_BACKUPS": "-1"}, wantErr: true},
+		{name: "zero alert interval", args: []string{"-alert-interval", "0s"}, wantErr: true},
+		{name: "invalid subnet", env: map[string]string{"TRUSTED_SUBNET": "10.0.0.0"}, wantErr: true},
+		{name: "import without bolt storage", args: []string{"-import", "metrics-db.json"}, wantErr: true},
+		{name: "unknown log level", args: []string{"-log-level", "loud"}, wantErr: true},
+		{name: "retention shorter than raw", args: []string{"-series-retention", "10m"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadServer(tt.args, envMap(tt.env))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestLoadAgentPrecedence(t *testing.T) {
+	file := writeFile(t, "agent.yml", `
+address: http://file:8080
+poll_interval: 1s
+report_interval: 20
+rate_limit: 4
+gc_pause_buckets: [0.001, 0.01]
+`)
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(t *testing.T, c Agent)
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, c Agent) {
+				assert.Equal(t, DefaultAgent(), c)
+				assert.Equal(t, "http://localhost:8080", c.ServerURL())
+			},
+		},
+		{
+			name: "file",
+			args: []string{"-c", file},
+			check: func(t *testing.T, c Agent) {
+				assert.Equal(t, "http://file:8080", c.ServerURL())
+				assert.Equal(t, time.Second, c.PollInterval.Duration)
+				assert.Equal(t, 20*time.Second, c.ReportInterval.Duration)
+				assert.Equal(t, 4, c.RateLimit)
+				assert.Equal(t, []float64{0.001, 0.01}, c.GCPauseBuckets)
+			},
+		},
+		{
+			name: "flags override file",
+			args: []string{"-c", file, "-l", "2", "-gc-buckets", "0.1,1"},
+			check: func(t *testing.T, c Agent) {
+				assert.Equal(t, 2, c.RateLimit)
+				assert.Equal(t, []float64{0.1, 1}, c.GCPauseBuckets)
+				assert.Equal(t, time.Second, c.PollInterval.Duration)
+			},
+		},
+		{
+			name: "env overrides flags",
+			args: []string{"-c", file, "-l", "2", "-p", "3"},
+			env:  map[string]string{"RATE_LIMIT": "8", "TRANSPORT": "grpc"},
+			check: func(t *testing.T, c Agent) {
+				assert.Equal(t, 8, c.RateLimit)
+				assert.Equal(t, 3*time.Second, c.PollInterval.Duration)
+				assert.Equal(t, "grpc", c.Transport)
+			},
+		},
+		{name: "bad env int", env: map[string]string{"RATE_LIMIT": "many"}, wantErr: true},
+		{name: "zero rate limit", args: []string{"-l", "0"}, wantErr: true},
+		{name: "zero poll interval", args: []string{"-p", "0"}, wantErr: true},
+		{name: "unsorted buckets", args: []string{"-gc-buckets", "1,0.1"}, wantErr: true},
+		{name: "unknown transport", args: []string{"-transport", "udp"}, wantErr: true},
+		{name: "crypto key with grpc", args: []string{"-transport", "grpc", "-crypto-key", "pub.pem"}, wantErr: true},
+		{name: "invalid address", args: []string{"-a", "http://localhost"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadAgent(tt.args, envMap(tt.env))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, c)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Server — настройки cmd/server.
+type Server struct {
+	Address             string   `json:"address" yaml:"address"`
+	GRPCAddress         string   `json:"grpc_address" yaml:"grpc_address"`
+	StoreInterval       Duration `json:"store_interval" yaml:"store_interval"`
+	FileStoragePath     string   `json:"file_storage_path" yaml:"file_storage_path"`
+	Restore             bool     `json:"restore" yaml:"restore"`
+	RestoreRecover      bool     `json:"restore_recover" yaml:"restore_recover"`
+	SnapshotBackups     int      `json:"snapshot_backups" yaml:"snapshot_backups"`
+	DatabaseDSN         string   `json:"database_dsn" yaml:"database_dsn"`
+	Storage             string   `json:"storage" yaml:"storage"`
+	ImportFile          string   `json:"import_file" yaml:"import_file"`
+	Key                 string   `json:"key" yaml:"key"`
+	CryptoKey           string   `json:"crypto_key" yaml:"crypto_key"`
+	TrustedSubnet       string   `json:"trusted_subnet" yaml:"trusted_subnet"`
+	AlertRules          string   `json:"alert_rules" yaml:"alert_rules"`
+	AlertInterval       Duration `json:"alert_interval" yaml:"alert_interval"`
+	AlertWebhooks       []string `json:"alert_webhooks" yaml:"alert_webhooks"`
+	AlertRepeatInterval Duration `json:"alert_repeat_interval" yaml:"alert_repeat_interval"`
+	SeriesRawRetention  Duration `json:"series_raw_retention" yaml:"series_raw_retention"`
+	SeriesResolution    Duration `json:"series_resolution" yaml:"series_resolution"`
+	SeriesRetention     Duration `json:"series_retention" yaml:"series_retention"`
+	LogLevel            string   `json:"log_level" yaml:"log_level"`
+}
+
+// DefaultServer возвращает настройки сервера по умолчанию.
+func DefaultServer() Server {
+	return Server{
+		Address:             "localhost:8080",
+		GRPCAddress:         "localhost:3200",
+		StoreInterval:       Seconds(300),
+		FileStoragePath:     "metrics-db.json",
+		Restore:             true,
+		SnapshotBackups:     3,
+		AlertInterval:       Seconds(10),
+		AlertRepeatInterval: Seconds(3600),
+		SeriesRawRetention:  Seconds(3600),
+		SeriesResolution:    Seconds(60),
+		SeriesRetention:     Seconds(86400),
+		LogLevel:            "info",
+	}
+}
+
+func (c *Server) options() []option {
+	return []option{
+		{"a", "ADDRESS", "HTTP server address", stringValue{&c.Address}},
+		{"g", "GRPC_ADDRESS", "gRPC server address (empty disables gRPC)", stringValue{&c.GRPCAddress}},
+		{"i", "STORE_INTERVAL", "Store interval: how often the write-ahead log is synced (10s or seconds, 0 syncs every write)", &c.StoreInterval},
+		{"f", "FILE_STORAGE_PATH", "File storage path", stringValue{&c.FileStoragePath}},
+		{"r", "RESTORE", "Restore from file on start", boolValue{&c.Restore}},
+		{"restore-recover", "RESTORE_RECOVER", "Fall back to the newest intact backup if the snapshot is corrupted (off by default)", boolValue{&c.RestoreRecover}},
+		{"snapshot-backups", "SNAPSHOT_BACKUPS", "Number of previous snapshots to keep as .bak.N", intValue{&c.SnapshotBackups}},
+		{"d", "DATABASE_DSN", "PostgreSQL connection string", stringValue{&c.DatabaseDSN}},
+		{"storage", "STORAGE", "Storage URL: mem://, file:///path, bolt:///path or postgres://... (overrides -d and -f)", stringValue{&c.Storage}},
+		{"import", "IMPORT_FILE", "File storage JSON to import into an empty bolt storage on start", stringValue{&c.ImportFile}},
+		{"k", "KEY", "Key for HMAC-SHA256 signing", stringValue{&c.Key}},
+		{"crypto-key", "CRYPTO_KEY", "Path to RSA private key (PEM) for decrypting agent payloads; requires an empty grpc address", stringValue{&c.CryptoKey}},
+		{"t", "TRUSTED_SUBNET", "Trusted agent subnet in CIDR notation (empty allows any)", stringValue{&c.TrustedSubnet}},
+		{"rules", "ALERT_RULES", "Alert rules file (YAML or JSON)", stringValue{&c.AlertRules}},
+		{"alert-interval", "ALERT_INTERVAL", "Alert evaluation interval", &c.AlertInterval},
+		{"webhooks", "ALERT_WEBHOOKS", "Comma-separated webhook URLs for alert notifications", listValue{&c.AlertWebhooks}},
+		{"alert-repeat", "ALERT_REPEAT_INTERVAL", "Repeat interval for firing alert notifications", &c.AlertRepeatInterval},
+		{"series-raw", "SERIES_RAW_RETENTION", "Retention of raw metric history", &c.SeriesRawRetention},
+		{"series-resolution", "SERIES_RESOLUTION", "Resolution of downsampled metric history", &c.SeriesResolution},
+		{"series-retention", "SERIES_RETENTION", "Total retention of metric history", &c.SeriesRetention},
+		{"log-level", "LOG_LEVEL", "Request log level: debug, info, warn or error", stringValue{&c.LogLevel}},
+	}
+}
+
+// LoadServer собирает настройки сервера из аргументов командной строки
+// args, окружения env и файла, заданного -c или CONFIG.
+func LoadServer(args []string, env LookupEnv) (Server, error) {
+	c := DefaultServer()
+	if err := load("server", &c, c.options(), args, env); err != nil {
+		return Server{}, err
+	}
+	if err := c.Validate(); err != nil {
+		return Server{}, err
+	}
+	return c, nil
+}
+
+// StorageDSN возвращает адрес хранилища для storage.Open: storage,
+// если задан, иначе database_dsn. Пустая строка означает файловое
+// хранилище file_storage_path: путь открывается как есть, без разбора
+// как URL.
+func (c Server) StorageDSN() string {
+	switch {
+	case c.Storage != "":
+		return c.Storage
+	case c.DatabaseDSN != "":
+		return c.DatabaseDSN
+	}
+	return ""
+}
+
+// Validate проверяет согласованность настроек.
+func (c Server) Validate() error {
+	var errs []error
+	if err := validAddress(c.Address); err != nil {
+		errs = append(errs, err)
+	}
+	if c.GRPCAddress != "" {
+		if err := validAddress(c.GRPCAddress); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.CryptoKey != "" && c.GRPCAddress != "" {
+		// gRPC-сервер принимает пакеты без шифрования.
+		errs = append(errs, errors.New("crypto key is not supported with grpc, set an empty grpc address"))
+	}
+	if c.StoreInterval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("store interval must not be negative, got %s", c.StoreInterval))
+	}
+	if c.SnapshotBackups < 0 {
+		errs = append(errs, fmt.Errorf("snapshot backups must not be negative, got %d", c.SnapshotBackups))
+	}
+	if c.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(c.TrustedSubnet); err != nil {
+			errs = append(errs, fmt.Errorf("invalid trusted subnet: %w", err))
+		}
+	}
+	if c.AlertInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("alert interval must be positive, got %s", c.AlertInterval))
+	}
+	if c.AlertRepeatInterval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("alert repeat interval must not be negative, got %s", c.AlertRepeatInterval))
+	}
+	if c.SeriesRawRetention.Duration <= 0 || c.SeriesResolution.Duration <= 0 || c.SeriesRetention.Duration < c.SeriesRawRetention.Duration {
+		errs = append(errs, fmt.Errorf("invalid series retention: raw=%s resolution=%s total=%s",
+			c.SeriesRawRetention, c.SeriesResolution, c.SeriesRetention))
+	}
+	if c.ImportFile != "" && !strings.HasPrefix(c.Storage, "bolt:") {
+		errs = append(errs, errors.New("import file requires bolt storage"))
+	}
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,78 @@
+package encryption
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	priv, err := GenerateKey(2048)
+	require.NoError(t, err)
+
+	data := []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)
+	payload, err := Encrypt(&priv.PublicKey, data)
+	require.NoError(t, err)
+	assert.NotContains(t, string(payload), "Alloc")
+
+	got, err := Decrypt(priv, payload)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	tampered := append([]byte(nil), payload...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = Decrypt(priv, tampered)
+	assert.Error(t, err)
+
+	other, err := GenerateKey(2048)
+	require.NoError(t, err)
+	_, err = Decrypt(other, payload)
+	assert.Error(t, err)
+
+	for _, bad := range [][]byte{nil, {0}, {0xff, 0xff, 1, 2}} {
+		_, err = Decrypt(priv, bad)
+		assert.ErrorIs(t, err, ErrMalformed)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	priv, err := GenerateKey(2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+		return path
+	}
+
+	privPEM, err := EncodePrivateKey(priv)
+	require.NoError(t, err)
+	pubPEM, err := EncodePublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	pkcs1Priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	pkcs1Pub := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&priv.PublicKey)})
+
+	for _, path := range []string{write("private.pem", privPEM), write("private-pkcs1.pem", pkcs1Priv)} {
+		got, err := LoadPrivateKey(path)
+		require.NoError(t, err, path)
+		assert.True(t, priv.Equal(got), path)
+	}
+	for _, path := range []string{write("public.pem", pubPEM), write("public-pkcs1.pem", pkcs1Pub)} {
+		got, err := LoadPublicKey(path)
+		require.NoError(t, err, path)
+		assert.True(t, priv.PublicKey.Equal(got), path)
+	}
+
+	_, err = LoadPrivateKey(write("public-as-private.pem", pubPEM))
+	assert.Error(t, err)
+	_, err = LoadPublicKey(write("garbage.pem", []byte("not a key")))
+	assert.Error(t, err)
+	_, err = LoadPublicKey(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+}
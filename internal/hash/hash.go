@@ -0,0 +1,75 @@
+package hash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+// Header — заголовок с HMAC-SHA256 подписью тела запроса или ответа.
+const Header = "HashSHA256"
+
+func Sign(key string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RequestData возвращает подписываемые данные запроса без тела: метод
+// и URI с параметрами. Подпись пустого тела одинакова для всех запросов
+// и не защищала бы от подмены пути.
+func RequestData(method, uri string) []byte {
+	return []byte(method + " " + uri)
+}
+
+func Verify(key string, data []byte, sum string) bool {
+	expected, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Metric подписывает отдельную метрику по её ключу (id с метками),
+// типу и значению. Для метрик без меток ключ совпадает с id.
+func Metric(key string, m models.Metrics) string {
+	var data string
+	switch m.MType {
+	case models.Gauge:
+		if m.Value == nil {
+			return ""
+		}
+		data = fmt.Sprintf("%s:gauge:%s", m.Key(), formatFloat(*m.Value))
+	case models.Counter:
+		if m.Delta == nil {
+			return ""
+		}
+		data = fmt.Sprintf("%s:counter:%d", m.Key(), *m.Delta)
+	case models.Histogram:
+		if m.Histogram == nil {
+			return ""
+		}
+		h := m.Histogram
+		data = fmt.Sprintf("%s:histogram:%v:%v:%s:%d", m.Key(), h.Bounds, h.Counts, formatFloat(h.Sum), h.Count)
+	default:
+		return ""
+	}
+	return Sign(key, []byte(data))
+}
+
+// formatFloat записывает число без потери точности, чтобы разные
+// значения давали разные подписи.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func VerifyMetric(key string, m models.Metrics) bool {
+	sum := Metric(key, m)
+	return sum != "" && hmac.Equal([]byte(sum), []byte(m.Hash))
+}
@@ -0,0 +1,28 @@
+package hash
+
+import (
+	"testing"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricDistinguishesSmallValues(t *testing.T) {
+	a, b := 1e-9, 2e-9
+	gauge := func(v *float64) models.Metrics {
+		return models.Metrics{ID: "Tiny", MType: models.Gauge, Value: v}
+	}
+	assert.NotEqual(t, Metric("k", gauge(&a)), Metric("k", gauge(&b)))
+
+	m := gauge(&a)
+	m.Hash = Metric("k", m)
+	m.Value = &b
+	assert.False(t, VerifyMetric("k", m), "changed value must not verify")
+
+	ha, hb := models.NewHistogram([]float64{1}), models.NewHistogram([]float64{1})
+	ha.Observe(1e-9)
+	hb.Observe(2e-9)
+	assert.NotEqual(t,
+		Metric("k", models.Metrics{ID: "Pause", MType: models.Histogram, Histogram: ha}),
+		Metric("k", models.Metrics{ID: "Pause", MType: models.Histogram, Histogram: hb}))
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultBuckets — верхние границы корзин гистограммы по умолчанию,
+// в секундах: от 10 мкс до 1 с.
+var DefaultBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.0005,
+	0.001, 0.005, 0.01, 0.05,
+	0.1, 0.5, 1,
+}
+
+var ErrBucketsMismatch = errors.New("histogram buckets mismatch")
+
+// HistogramValue хранит распределение наблюдений. Bounds — возрастающие
+// верхние границы корзин, Counts[i] — число наблюдений в корзине
+// (Bounds[i-1], Bounds[i]], последний элемент Counts считает значения
+// больше всех границ. Count и Sum — общее число и сумма наблюдений.
+type HistogramValue struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// NewHistogram создаёт пустую гистограмму с копией границ bounds.
+func NewHistogram(bounds []float64) *HistogramValue {
+	return &HistogramValue{
+		Bounds: append([]float64(nil), bounds...),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe добавляет одно наблюдение.
+func (h *HistogramValue) Observe(v float64) {
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+// Merge прибавляет к h наблюдения other. Гистограммы с разными
+// границами не складываются.
+func (h *HistogramValue) Merge(other HistogramValue) error {
+	if !h.SameBounds(other) {
+		return ErrBucketsMismatch
+	}
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+	return nil
+}
+
+func (h HistogramValue) SameBounds(other HistogramValue) bool {
+	if len(h.Bounds) != len(other.Bounds) {
+		return false
+	}
+	for i, b := range h.Bounds {
+		if b != other.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (h HistogramValue) Clone() HistogramValue {
+	h.Bounds = append([]float64(nil), h.Bounds...)
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// Validate проверяет согласованность границ и счётчиков.
+func (h HistogramValue) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%d counts for %d buckets", len(h.Counts), len(h.Bounds))
+	}
+	for i, b := range h.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return fmt.Errorf("bad bucket bound %v", b)
+		}
+		if i > 0 && b <= h.Bounds[i-1] {
+			return fmt.Errorf("bucket bounds are not increasing")
+		}
+	}
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return fmt.Errorf("count %d does not match buckets total %d", h.Count, total)
+	}
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return fmt.Errorf("bad sum %v", h.Sum)
+	}
+	return nil
+}
+
+// Cumulative возвращает накопленные счётчики корзин, как в Prometheus:
+// i-й элемент — число наблюдений не больше Bounds[i], последний — Count.
+func (h HistogramValue) Cumulative() []uint64 {
+	res := make([]uint64, len(h.Counts))
+	var acc uint64
+	for i, c := range h.Counts {
+		acc += c
+		res[i] = acc
+	}
+	return res
+}
+
+// String возвращает текстовое представление для text/plain ответов:
+// count=7 sum=0.12 buckets=[0.001:3 0.01:5 +Inf:7].
+func (h HistogramValue) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "count=%d sum=%s buckets=[", h.Count, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	for i, c := range h.Cumulative() {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = strconv.FormatFloat(h.Bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(&b, "%s:%d", le, c)
+	}
+	b.WriteByte(']')
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := NewHistogram([]float64{1, 5})
+	for _, v := range []float64{0.5, 1, 2, 5, 10} {
+		h.Observe(v)
+	}
+
+	assert.Equal(t, []uint64{2, 2, 1}, h.Counts)
+	assert.Equal(t, []uint64{2, 4, 5}, h.Cumulative())
+	assert.Equal(t, uint64(5), h.Count)
+	assert.Equal(t, 18.5, h.Sum)
+	assert.NoError(t, h.Validate())
+	assert.Equal(t, "count=5 sum=18.5 buckets=[1:2 5:4 +Inf:5]", h.String())
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a := NewHistogram([]float64{1})
+	a.Observe(0.5)
+	b := NewHistogram([]float64{1})
+	b.Observe(2)
+
+	require.NoError(t, a.Merge(*b))
+	assert.Equal(t, []uint64{1, 1}, a.Counts)
+	assert.Equal(t, uint64(2), a.Count)
+
+	assert.ErrorIs(t, a.Merge(*NewHistogram([]float64{2})), ErrBucketsMismatch)
+	assert.Equal(t, uint64(2), a.Count)
+}
+
+func TestHistogramValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		h    HistogramValue
+	}{
+		{"counts length", HistogramValue{Bounds: []float64{1}, Counts: []uint64{1}, Count: 1}},
+		{"unsorted bounds", HistogramValue{Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0}}},
+		{"count mismatch", HistogramValue{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 1}},
+		{"infinite bound", HistogramValue{Bounds: []float64{math.Inf(1)}, Counts: []uint64{0, 0}}},
+		{"infinite sum", HistogramValue{Bounds: []float64{1}, Counts: []uint64{0, 1}, Count: 1, Sum: math.Inf(1)}},
+		{"nan sum", HistogramValue{Bounds: []float64{1}, Counts: []uint64{0, 1}, Count: 1, Sum: math.NaN()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.h.Validate())
+		})
+	}
+}
+
+func TestMergeHistograms(t *testing.T) {
+	h1 := NewHistogram([]float64{1})
+	h1.Observe(0.5)
+	h2 := NewHistogram([]float64{1})
+	h2.Observe(3)
+	h3 := NewHistogram([]float64{10})
+	h3.Observe(3)
+
+	merged := Merge([]Metrics{
+		{ID: "h", MType: Histogram, Histogram: h1},
+		{ID: "h", MType: Histogram, Histogram: h2},
+	})
+	require.Len(t, merged, 1)
+	assert.Equal(t, []uint64{1, 1}, merged[0].Histogram.Counts)
+	assert.Equal(t, []uint64{1, 0}, h1.Counts, "input must not be modified")
+
+	merged = Merge([]Metrics{
+		{ID: "h", MType: Histogram, Histogram: h1},
+		{ID: "h", MType: Histogram, Histogram: h3},
+	})
+	require.Len(t, merged, 1)
+	assert.Equal(t, []float64{10}, merged[0].Histogram.Bounds)
+}
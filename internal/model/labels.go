@@ -0,0 +1,111 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Key возвращает ключ хранения метрики: id без меток или
+// id{name="value",...} с метками, отсортированными по имени.
+func Key(id string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return id
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(id)
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func (m Metrics) Key() string {
+	return Key(m.ID, m.Labels)
+}
+
+// ParseKey разбирает ключ, построенный Key. Строка, не похожая
+// на ключ с метками, целиком считается id.
+func ParseKey(key string) (string, map[string]string) {
+	open := strings.IndexByte(key, '{')
+	if open <= 0 || !strings.HasSuffix(key, "}") {
+		return key, nil
+	}
+
+	labels, err := parseLabels(key[open+1 : len(key)-1])
+	if err != nil {
+		return key, nil
+	}
+	return key[:open], labels
+}
+
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for s != "" {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok || !ValidLabelName(name) {
+			return nil, fmt.Errorf("bad label in %q", s)
+		}
+		value, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, err
+		}
+		labels[name], _ = strconv.Unquote(value)
+
+		s = rest[len(value):]
+		if s != "" {
+			if s[0] != ',' {
+				return nil, fmt.Errorf("bad label separator in %q", s)
+			}
+			s = s[1:]
+		}
+	}
+	return labels, nil
+}
+
+// ValidID проверяет id метрики: он не пуст и не содержит '{', иначе
+// ключ id с метками нельзя отличить от ключа другой метрики.
+func ValidID(id string) bool {
+	return id != "" && !strings.ContainsRune(id, '{')
+}
+
+// ValidLabelName проверяет имя метки на соответствие [a-zA-Z_][a-zA-Z0-9_]*.
+func ValidLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// MatchLabels сообщает, содержит ли labels все пары из filter.
+func MatchLabels(labels, filter map[string]string) bool {
+	for name, value := range filter {
+		if v, ok := labels[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
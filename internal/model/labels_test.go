@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		labels map[string]string
+		key    string
+	}{
+		{"no labels", "Alloc", nil, "Alloc"},
+		{"sorted labels", "Alloc", map[string]string{"instance": "b", "host": "a"}, `Alloc{host="a",instance="b"}`},
+		{"quoted value", "Alloc", map[string]string{"host": `a,b="c"}`}, `Alloc{host="a,b=\"c\"}"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := Key(tt.id, tt.labels)
+			assert.Equal(t, tt.key, key)
+
+			id, labels := ParseKey(key)
+			assert.Equal(t, tt.id, id)
+			assert.Equal(t, len(tt.labels), len(labels))
+			assert.True(t, MatchLabels(labels, tt.labels))
+		})
+	}
+}
+
+func TestParseKeyFallback(t *testing.T) {
+	for _, key := range []string{"{x}", "a{b}", `a{b="c"`, `a{1="c"}`, `a{b="c"d="e"}`} {
+		id, labels := ParseKey(key)
+		assert.Equal(t, key, id)
+		assert.Nil(t, labels)
+	}
+}
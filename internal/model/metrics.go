@@ -1,8 +1,9 @@
 package models
 
 const (
-	Counter = "counter"
-	Gauge   = "gauge"
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
 )
 
 // NOTE: Не усложняем пример, вводя иерархическую вложенность структур.
@@ -16,4 +17,78 @@ type Metrics struct {
 	Delta *int64   `json:"delta,omitempty"`
 	Value *float64 `json:"value,omitempty"`
 	Hash  string   `json:"hash,omitempty"`
+	// Labels различают одноимённые метрики разных хостов.
+	// Метрики без меток хранятся под ключом ID, как и раньше.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Histogram задан только для метрик типа histogram и, как Delta,
+	// содержит наблюдения с прошлой отправки.
+	Histogram *HistogramValue `json:"histogram,omitempty"`
+}
+
+// Merge схлопывает повторяющиеся метрики пакета: дельты счётчиков
+// и гистограммы суммируются, для gauge остаётся последнее значение.
+// Гистограмма с другими границами корзин заменяет предыдущую.
+// Порядок первых вхождений сохраняется.
+func Merge(batch []Metrics) []Metrics {
+	type key struct{ id, mtype string }
+
+	idx := make(map[key]int, len(batch))
+	res := make([]Metrics, 0, len(batch))
+
+	for _, m := range batch {
+		k := key{m.Key(), m.MType}
+		i, ok := idx[k]
+		if !ok {
+			idx[k] = len(res)
+			res = append(res, copyMetric(m))
+			continue
+		}
+
+		switch m.MType {
+		case Counter:
+			if m.Delta == nil {
+				continue
+			}
+			sum := *m.Delta
+			if res[i].Delta != nil {
+				sum += *res[i].Delta
+			}
+			res[i].Delta = &sum
+		case Histogram:
+			if m.Histogram == nil {
+				continue
+			}
+			if res[i].Histogram != nil && res[i].Histogram.Merge(*m.Histogram) == nil {
+				continue
+			}
+			res[i] = copyMetric(m)
+		default:
+			res[i] = copyMetric(m)
+		}
+	}
+
+	return res
+}
+
+func copyMetric(m Metrics) Metrics {
+	if m.Delta != nil {
+		d := *m.Delta
+		m.Delta = &d
+	}
+	if m.Value != nil {
+		v := *m.Value
+		m.Value = &v
+	}
+	if m.Histogram != nil {
+		h := m.Histogram.Clone()
+		m.Histogram = &h
+	}
+	if m.Labels != nil {
+		labels := make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			labels[k] = v
+		}
+		m.Labels = labels
+	}
+	return m
 }
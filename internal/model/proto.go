@@ -0,0 +1,59 @@
+package models
+
+import "github.com/LemuriiL/MetricsAllerts/pkg/metricspb"
+
+var (
+	protoTypes = map[string]metricspb.Metric_Type{
+		Gauge:     metricspb.Metric_GAUGE,
+		Counter:   metricspb.Metric_COUNTER,
+		Histogram: metricspb.Metric_HISTOGRAM,
+	}
+	modelTypes = map[metricspb.Metric_Type]string{
+		metricspb.Metric_GAUGE:     Gauge,
+		metricspb.Metric_COUNTER:   Counter,
+		metricspb.Metric_HISTOGRAM: Histogram,
+	}
+)
+
+// ToProto переводит метрику в сообщение gRPC.
+func ToProto(m Metrics) *metricspb.Metric {
+	pm := &metricspb.Metric{
+		Id:     m.ID,
+		Type:   protoTypes[m.MType],
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Hash:   m.Hash,
+		Labels: m.Labels,
+	}
+	if m.Histogram != nil {
+		pm.Histogram = &metricspb.Histogram{
+			Bounds: m.Histogram.Bounds,
+			Counts: m.Histogram.Counts,
+			Sum:    m.Histogram.Sum,
+			Count:  m.Histogram.Count,
+		}
+	}
+	return pm
+}
+
+// FromProto переводит сообщение gRPC в метрику. Неизвестный тип
+// превращается в пустую строку и отклоняется валидацией.
+func FromProto(pm *metricspb.Metric) Metrics {
+	m := Metrics{
+		ID:     pm.GetId(),
+		MType:  modelTypes[pm.GetType()],
+		Delta:  pm.Delta,
+		Value:  pm.Value,
+		Hash:   pm.GetHash(),
+		Labels: pm.GetLabels(),
+	}
+	if ph := pm.GetHistogram(); ph != nil {
+		m.Histogram = &HistogramValue{
+			Bounds: ph.GetBounds(),
+			Counts: ph.GetCounts(),
+			Sum:    ph.GetSum(),
+			Count:  ph.GetCount(),
+		}
+	}
+	return m
+}
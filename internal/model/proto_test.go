@@ -0,0 +1,26 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/LemuriiL/MetricsAllerts/pkg/metricspb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtoRoundTrip(t *testing.T) {
+	val := 1.5
+	delta := int64(3)
+	h := NewHistogram([]float64{1, 2})
+	h.Observe(1.5)
+
+	for _, m := range []Metrics{
+		{ID: "Alloc", MType: Gauge, Value: &val, Labels: map[string]string{"host": "a"}},
+		{ID: "PollCount", MType: Counter, Delta: &delta, Hash: "abc"},
+		{ID: "GCPause", MType: Histogram, Histogram: h},
+	} {
+		assert.Equal(t, m, FromProto(ToProto(m)), m.ID)
+	}
+
+	m := FromProto(&metricspb.Metric{Id: "X", Type: metricspb.Metric_TYPE_UNSPECIFIED})
+	assert.Empty(t, m.MType)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"io"
+	"net/http"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/encryption"
+)
+
+// decryptMiddleware расшифровывает тела запросов с заголовком
+// encryption.Header. Если ключ задан, запросы на изменение метрик без
+// заголовка отклоняются с 400; чтение пропускается как есть.
+// Агент сжимает тело до шифрования, поэтому middleware подключается
+// перед gzipMiddleware.
+func decryptMiddleware(key *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme := r.Header.Get(encryption.Header)
+			if key == nil || (scheme == "" && !isWrite(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if scheme == "" {
+				http.Error(w, "encryption required", http.StatusBadRequest)
+				return
+			}
+			if scheme != encryption.Scheme {
+				http.Error(w, "unsupported encryption", http.StatusBadRequest)
+				return
+			}
+
+			payload, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			body, err := encryption.Decrypt(key, payload)
+			if err != nil {
+				http.Error(w, "cannot decrypt body", http.StatusBadRequest)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Del(encryption.Header)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
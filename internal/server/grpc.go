@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/hash"
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/LemuriiL/MetricsAllerts/pkg/metricspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxStreamMetrics ограничивает размер пакета, собираемого StreamMetrics.
+const maxStreamMetrics = 100000
+
+// metricsService реализует gRPC-приём метрик поверх того же Handler,
+// что и HTTP: проверки и запись пакета выполняет updateBatch.
+type metricsService struct {
+	metricspb.UnimplementedMetricsServiceServer
+	handler *Handler
+}
+
+func (s *metricsService) UpdateMetrics(ctx context.Context, req *metricspb.UpdateMetricsRequest) (*metricspb.UpdateMetricsResponse, error) {
+	return s.update(fromProto(nil, req.GetMetrics()))
+}
+
+func (s *metricsService) StreamMetrics(stream metricspb.MetricsService_StreamMetricsServer) error {
+	var batch []models.Metrics
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(batch)+len(req.GetMetrics()) > maxStreamMetrics {
+			return status.Errorf(codes.ResourceExhausted, "stream exceeds %d metrics", maxStreamMetrics)
+		}
+		batch = fromProto(batch, req.GetMetrics())
+	}
+
+	resp, err := s.update(batch)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp)
+}
+
+func (s *metricsService) update(batch []models.Metrics) (*metricspb.UpdateMetricsResponse, error) {
+	batch, err := s.handler.updateBatch(batch, s.verify)
+	switch {
+	case errors.Is(err, storage.ErrInvalidMetric), errors.Is(err, errHashMismatch):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		log.Printf("grpc: update metrics: %v", err)
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+	return &metricspb.UpdateMetricsResponse{Accepted: uint32(len(batch))}, nil
+}
+
+// verify требует подпись у каждой метрики, если задан ключ: в отличие
+// от HTTP, подписи всего тела запроса у gRPC нет.
+func (s *metricsService) verify(m models.Metrics) bool {
+	return s.handler.key == "" || hash.VerifyMetric(s.handler.key, m)
+}
+
+func fromProto(dst []models.Metrics, metrics []*metricspb.Metric) []models.Metrics {
+	for _, pm := range metrics {
+		dst = append(dst, models.FromProto(pm))
+	}
+	return dst
+}
+
+// GRPCServer возвращает gRPC-сервер с зарегистрированным MetricsService.
+func (s *Server) GRPCServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := s.checkCall(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.checkCall(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	metricspb.RegisterMetricsServiceServer(srv, &metricsService{handler: s.handler})
+	return srv
+}
+
+// checkCall применяет к gRPC-вызовам те же ограничения, что и к записи
+// по HTTP: все методы MetricsService записывают метрики.
+func (s *Server) checkCall(ctx context.Context) error {
+	if !s.handler.ready.Load() {
+		return status.Error(codes.Unavailable, "server is not ready")
+	}
+	return trustedCall(ctx, s.trustedSubnet)
+}
+
+// RunGRPC слушает addr до отмены ctx, после чего дожидается
+// завершения обрабатываемых вызовов.
+func (s *Server) RunGRPC(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeGRPC(ctx, ln)
+}
+
+func (s *Server) ServeGRPC(ctx context.Context, ln net.Listener) error {
+	srv := s.GRPCServer()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		srv.Stop()
+	}
+	return <-errCh
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/hash"
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/LemuriiL/MetricsAllerts/pkg/metricspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func startGRPC(t *testing.T, srv *Server) metricspb.MetricsServiceClient {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ServeGRPC(ctx, ln)
+	}()
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Error("gRPC server did not stop")
+		}
+	})
+	return metricspb.NewMetricsServiceClient(conn)
+}
+
+func protoBatch(metrics ...models.Metrics) []*metricspb.Metric {
+	res := make([]*metricspb.Metric, len(metrics))
+	for i, m := range metrics {
+		res[i] = models.ToProto(m)
+	}
+	return res
+}
+
+func TestGRPCUpdateMetrics(t *testing.T) {
+	store := storage.NewMemStorage()
+	client := startGRPC(t, New(store))
+	ctx := context.Background()
+
+	val := 1.5
+	d1, d2 := int64(2), int64(3)
+	h := models.NewHistogram([]float64{1})
+	h.Observe(0.5)
+
+	resp, err := client.UpdateMetrics(ctx, &metricspb.UpdateMetricsRequest{Metrics: protoBatch(
+		models.Metrics{ID: "Alloc", MType: models.Gauge, Value: &val, Labels: map[string]string{"host": "a"}},
+		models.Metrics{ID: "PollCount", MType: models.Counter, Delta: &d1},
+		models.Metrics{ID: "PollCount", MType: models.Counter, Delta: &d2},
+		models.Metrics{ID: "GCPause", MType: models.Histogram, Histogram: h},
+	)})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), resp.GetAccepted())
+
+	g, ok := store.GetGauge(`Alloc{host="a"}`)
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, g)
+	c, _ := store.GetCounter("PollCount")
+	assert.Equal(t, int64(5), c)
+	hv, ok := store.GetHistogram("GCPause")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), hv.Count)
+
+	_, err = client.UpdateMetrics(ctx, &metricspb.UpdateMetricsRequest{Metrics: protoBatch(
+		models.Metrics{ID: "PollCount", MType: models.Counter, Delta: &d1},
+		models.Metrics{ID: "Broken", MType: models.Gauge},
+	)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	c, _ = store.GetCounter("PollCount")
+	assert.Equal(t, int64(5), c, "invalid batch must not be applied")
+
+	_, err = client.UpdateMetrics(ctx, &metricspb.UpdateMetricsRequest{Metrics: []*metricspb.Metric{
+		{Id: "X", Type: metricspb.Metric_TYPE_UNSPECIFIED, Value: &val},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCStreamMetrics(t *testing.T) {
+	store := storage.NewMemStorage()
+	client := startGRPC(t, New(store))
+
+	send := func(chunks ...[]*metricspb.Metric) (*metricspb.UpdateMetricsResponse, error) {
+		stream, err := client.StreamMetrics(context.Background())
+		require.NoError(t, err)
+		for _, chunk := range chunks {
+			require.NoError(t, stream.Send(&metricspb.UpdateMetricsRequest{Metrics: chunk}))
+		}
+		return stream.CloseAndRecv()
+	}
+
+	d := int64(1)
+	counter := models.Metrics{ID: "PollCount", MType: models.Counter, Delta: &d}
+
+	resp, err := send(protoBatch(counter, counter), protoBatch(counter))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), resp.GetAccepted())
+	c, _ := store.GetCounter("PollCount")
+	assert.Equal(t, int64(3), c)
+
+	_, err = send(protoBatch(counter), protoBatch(models.Metrics{ID: "", MType: models.Counter, Delta: &d}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	c, _ = store.GetCounter("PollCount")
+	assert.Equal(t, int64(3), c, "stream is applied only as a whole")
+}
+
+func TestGRPCRequiresMetricHash(t *testing.T) {
+	const key = "secret"
+	store := storage.NewMemStorage()
+	client := startGRPC(t, New(store, WithKey(key)))
+
+	val := 1.0
+	m := models.Metrics{ID: "Alloc", MType: models.Gauge, Value: &val}
+
+	_, err := client.UpdateMetrics(context.Background(), &metricspb.UpdateMetricsRequest{Metrics: protoBatch(m)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	m.Hash = hash.Metric(key, m)
+	_, err = client.UpdateMetrics(context.Background(), &metricspb.UpdateMetricsRequest{Metrics: protoBatch(m)})
+	assert.NoError(t, err)
+}
+
+func TestGRPCTrustedSubnet(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	client := startGRPC(t, New(storage.NewMemStorage(), WithTrustedSubnet(subnet)))
+
+	val := 1.0
+	req := &metricspb.UpdateMetricsRequest{Metrics: protoBatch(models.Metrics{ID: "Alloc", MType: models.Gauge, Value: &val})}
+
+	_, err = client.UpdateMetrics(context.Background(), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-real-ip", "192.168.0.1")
+	_, err = client.UpdateMetrics(ctx, req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-real-ip", "10.1.2.3")
+	_, err = client.UpdateMetrics(ctx, req)
+	assert.NoError(t, err)
+}
+
+func TestGRPCRejectsWritesUntilReady(t *testing.T) {
+	srv := New(storage.NewMemStorage())
+	srv.SetReady(false)
+	client := startGRPC(t, srv)
+
+	val := 1.0
+	req := &metricspb.UpdateMetricsRequest{Metrics: protoBatch(models.Metrics{ID: "Alloc", MType: models.Gauge, Value: &val})}
+	_, err := client.UpdateMetrics(context.Background(), req)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	srv.SetReady(true)
+	_, err = client.UpdateMetrics(context.Background(), req)
+	assert.NoError(t, err)
+}
@@ -2,20 +2,30 @@ package server
 
 import (
 	"fmt"
+	"html"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 
+	"github.com/LemuriiL/MetricsAllerts/internal/alerting"
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
 	"github.com/LemuriiL/MetricsAllerts/internal/storage"
 	"github.com/gorilla/mux"
 )
 
 type Handler struct {
 	storage storage.Storage
+	alerts  *alerting.Engine
+	key     string
+	ready   atomic.Bool
 }
 
 func NewHandler(s storage.Storage) *Handler {
-	return &Handler{storage: s}
+	h := &Handler{storage: s}
+	h.ready.Store(true)
+	return h
 }
 
 func (h *Handler) UpdateMetric(w http.ResponseWriter, r *http.Request) {
@@ -31,6 +41,13 @@ func (h *Handler) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	labels, ok := queryLabels(r)
+	if !ok || !models.ValidID(metricName) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	key := models.Key(metricName, labels)
+
 	switch metricType {
 	case "gauge":
 		val, err := strconv.ParseFloat(metricValueStr, 64)
@@ -38,7 +55,7 @@ func (h *Handler) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		h.storage.SetGauge(metricName, val)
+		h.storage.SetGauge(key, val)
 
 	case "counter":
 		val, err := strconv.ParseInt(metricValueStr, 10, 64)
@@ -46,7 +63,15 @@ func (h *Handler) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		h.storage.SetCounter(metricName, val)
+		h.storage.SetCounter(key, val)
+
+	case "histogram":
+		val, err := strconv.ParseFloat(metricValueStr, 64)
+		if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		h.storage.SetHistogram(key, observation(h.storage, key, val))
 
 	default:
 		http.Error(w, "bad request", http.StatusBadRequest)
@@ -61,40 +86,237 @@ func (h *Handler) GetMetricValue(w http.ResponseWriter, r *http.Request) {
 	metricType := vars["type"]
 	metricName := vars["name"]
 
+	filter, ok := queryLabels(r)
+	if !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		body    string
+		matches int
+	)
 	switch metricType {
 	case "gauge":
-		if val, ok := h.storage.GetGauge(metricName); ok {
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			fmt.Fprintf(w, "%g", val)
-			return
-		}
+		var val float64
+		_, val, matches = findGauge(h.storage, metricName, filter)
+		body = fmt.Sprintf("%g", val)
 	case "counter":
-		if val, ok := h.storage.GetCounter(metricName); ok {
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			fmt.Fprintf(w, "%d", val)
-			return
-		}
+		var val int64
+		_, val, matches = findCounter(h.storage, metricName, filter)
+		body = fmt.Sprintf("%d", val)
+	case "histogram":
+		var val models.HistogramValue
+		_, val, matches = findHistogram(h.storage, metricName, filter)
+		body = val.String()
 	default:
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	http.NotFound(w, r)
+	switch {
+	case matches == 0:
+		http.NotFound(w, r)
+	case matches > 1:
+		http.Error(w, "ambiguous metric, specify labels", http.StatusBadRequest)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, body)
+	}
+}
+
+// DeleteMetric удаляет метрику. Серия выбирается так же, как в
+// GetMetricValue: по имени и фильтру меток из параметров запроса.
+func (h *Handler) DeleteMetric(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	metricType := vars["type"]
+	metricName := vars["name"]
+
+	log.Printf("delete metric: type=%s name=%s", metricType, metricName)
+
+	key, ok := h.resolve(w, r, metricType, metricName)
+	if !ok {
+		return
+	}
+	deleted, err := h.storage.Delete(metricType, key)
+	if err != nil {
+		log.Printf("delete metric: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ResetCounter обнуляет счётчик, не удаляя его.
+func (h *Handler) ResetCounter(w http.ResponseWriter, r *http.Request) {
+	metricName := mux.Vars(r)["name"]
+
+	log.Printf("reset counter: name=%s", metricName)
+
+	key, ok := h.resolve(w, r, models.Counter, metricName)
+	if !ok {
+		return
+	}
+	reset, err := h.storage.ResetCounter(key)
+	if err != nil {
+		log.Printf("reset counter: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !reset {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// resolve находит ключ единственной серии метрики по имени и меткам
+// запроса. Если серия не найдена или не единственна, пишет ответ
+// с ошибкой и возвращает false.
+func (h *Handler) resolve(w http.ResponseWriter, r *http.Request, metricType, metricName string) (string, bool) {
+	filter, ok := queryLabels(r)
+	if !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return "", false
+	}
+
+	var (
+		key     string
+		matches int
+	)
+	switch metricType {
+	case models.Gauge:
+		key, _, matches = findGauge(h.storage, metricName, filter)
+	case models.Counter:
+		key, _, matches = findCounter(h.storage, metricName, filter)
+	case models.Histogram:
+		key, _, matches = findHistogram(h.storage, metricName, filter)
+	default:
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return "", false
+	}
+
+	switch {
+	case matches == 0:
+		http.NotFound(w, r)
+		return "", false
+	case matches > 1:
+		http.Error(w, "ambiguous metric, specify labels", http.StatusBadRequest)
+		return "", false
+	}
+	return key, true
 }
 
 func (h *Handler) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
+	filter, ok := queryLabels(r)
+	if !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
 	gauges := h.storage.GetAllGauges()
 	counters := h.storage.GetAllCounters()
+	histograms := h.storage.GetAllHistograms()
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprintln(w, "<h1>All Metrics</h1>")
 	fmt.Fprintln(w, "<h2>Gauges</h2><ul>")
-	for name, value := range gauges {
-		fmt.Fprintf(w, "<li>%s: %g</li>", name, value)
+	for key, value := range gauges {
+		if _, labels := models.ParseKey(key); models.MatchLabels(labels, filter) {
+			fmt.Fprintf(w, "<li>%s: %g</li>", html.EscapeString(key), value)
+		}
 	}
 	fmt.Fprintln(w, "</ul><h2>Counters</h2><ul>")
-	for name, value := range counters {
-		fmt.Fprintf(w, "<li>%s: %d</li>", name, value)
+	for key, value := range counters {
+		if _, labels := models.ParseKey(key); models.MatchLabels(labels, filter) {
+			fmt.Fprintf(w, "<li>%s: %d</li>", html.EscapeString(key), value)
+		}
+	}
+	fmt.Fprintln(w, "</ul><h2>Histograms</h2><ul>")
+	for key, value := range histograms {
+		if _, labels := models.ParseKey(key); models.MatchLabels(labels, filter) {
+			fmt.Fprintf(w, "<li>%s: %s</li>", html.EscapeString(key), value)
+		}
 	}
 	fmt.Fprintln(w, "</ul>")
 }
+
+// queryLabels собирает фильтр меток из параметров запроса,
+// пропуская служебные параметры reserved.
+func queryLabels(r *http.Request, reserved ...string) (map[string]string, bool) {
+	q := r.URL.Query()
+	for _, name := range reserved {
+		q.Del(name)
+	}
+	if len(q) == 0 {
+		return nil, true
+	}
+
+	labels := make(map[string]string, len(q))
+	for name, values := range q {
+		if !models.ValidLabelName(name) {
+			return nil, false
+		}
+		labels[name] = values[len(values)-1]
+	}
+	return labels, true
+}
+
+// findGauge ищет gauge по id и фильтру меток. Точное совпадение ключа
+// имеет приоритет; иначе подходят все метрики с этим id, чьи метки
+// содержат фильтр. Возвращает ключ, значение и число совпадений.
+func findGauge(s storage.Storage, id string, filter map[string]string) (string, float64, int) {
+	key := models.Key(id, filter)
+	if v, ok := s.GetGauge(key); ok {
+		return key, v, 1
+	}
+	return findByLabels(s.GetAllGauges(), id, filter)
+}
+
+func findCounter(s storage.Storage, id string, filter map[string]string) (string, int64, int) {
+	key := models.Key(id, filter)
+	if v, ok := s.GetCounter(key); ok {
+		return key, v, 1
+	}
+	return findByLabels(s.GetAllCounters(), id, filter)
+}
+
+func findHistogram(s storage.Storage, id string, filter map[string]string) (string, models.HistogramValue, int) {
+	key := models.Key(id, filter)
+	if v, ok := s.GetHistogram(key); ok {
+		return key, v, 1
+	}
+	return findByLabels(s.GetAllHistograms(), id, filter)
+}
+
+// observation строит гистограмму из одного наблюдения v с границами
+// уже сохранённой гистограммы key или с границами по умолчанию.
+func observation(s storage.Storage, key string, v float64) models.HistogramValue {
+	bounds := models.DefaultBuckets
+	if old, ok := s.GetHistogram(key); ok {
+		bounds = old.Bounds
+	}
+	h := models.NewHistogram(bounds)
+	h.Observe(v)
+	return *h
+}
+
+func findByLabels[T any](all map[string]T, id string, filter map[string]string) (string, T, int) {
+	var (
+		foundKey string
+		found    T
+		matches  int
+	)
+	for key, v := range all {
+		kid, labels := models.ParseKey(key)
+		if kid == id && models.MatchLabels(labels, filter) {
+			foundKey, found = key, v
+			matches++
+		}
+	}
+	return foundKey, found, matches
+}
@@ -2,9 +2,17 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/LemuriiL/MetricsAllerts/internal/alerting"
+	"github.com/LemuriiL/MetricsAllerts/internal/hash"
 	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/gorilla/mux"
 )
 
 func (h *Handler) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
@@ -19,24 +27,47 @@ func (h *Handler) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.verify(m) {
+		http.Error(w, "hash mismatch", http.StatusBadRequest)
+		return
+	}
+
+	if !models.ValidID(m.ID) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	for name := range m.Labels {
+		if !models.ValidLabelName(name) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
 	switch m.MType {
 	case models.Gauge:
 		if m.Value == nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		h.storage.SetGauge(m.ID, *m.Value)
+		h.storage.SetGauge(m.Key(), *m.Value)
 	case models.Counter:
 		if m.Delta == nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		h.storage.SetCounter(m.ID, *m.Delta)
+		h.storage.SetCounter(m.Key(), *m.Delta)
+	case models.Histogram:
+		if m.Histogram == nil || m.Histogram.Validate() != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		h.storage.SetHistogram(m.Key(), *m.Histogram)
 	default:
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
+	h.sign(&m)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(m)
 }
@@ -53,26 +84,198 @@ func (h *Handler) GetMetricJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var (
+		key     string
+		matches int
+	)
 	switch m.MType {
 	case models.Gauge:
-		val, ok := h.storage.GetGauge(m.ID)
-		if !ok {
-			http.NotFound(w, r)
-			return
-		}
+		var val float64
+		key, val, matches = findGauge(h.storage, m.ID, m.Labels)
 		m.Value = &val
 	case models.Counter:
-		val, ok := h.storage.GetCounter(m.ID)
-		if !ok {
-			http.NotFound(w, r)
-			return
-		}
+		var val int64
+		key, val, matches = findCounter(h.storage, m.ID, m.Labels)
 		m.Delta = &val
+	case models.Histogram:
+		var val models.HistogramValue
+		key, val, matches = findHistogram(h.storage, m.ID, m.Labels)
+		m.Histogram = &val
 	default:
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
+	switch {
+	case matches == 0:
+		http.NotFound(w, r)
+		return
+	case matches > 1:
+		http.Error(w, "ambiguous metric, specify labels", http.StatusBadRequest)
+		return
+	}
+
+	_, m.Labels = models.ParseKey(key)
+	h.sign(&m)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(m)
 }
+
+func (h *Handler) UpdateMetricsJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var batch []models.Metrics
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	batch, err := h.updateBatch(batch, h.verify)
+	switch {
+	case errors.Is(err, storage.ErrInvalidMetric):
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	case errors.Is(err, errHashMismatch):
+		http.Error(w, "hash mismatch", http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range batch {
+		h.sign(&batch[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
+func (h *Handler) GetAlerts(w http.ResponseWriter, r *http.Request) {
+	alerts := []alerting.Alert{}
+	if h.alerts != nil {
+		alerts = h.alerts.Alerts()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+var errHashMismatch = errors.New("hash mismatch")
+
+// updateBatch проверяет пакет, схлопывает повторы и записывает его
+// в хранилище. Общая часть HTTP- и gRPC-приёма пакетов.
+func (h *Handler) updateBatch(batch []models.Metrics, verify func(models.Metrics) bool) ([]models.Metrics, error) {
+	if err := storage.ValidateBatch(batch); err != nil {
+		return nil, err
+	}
+
+	for _, m := range batch {
+		if !verify(m) {
+			return nil, fmt.Errorf("%w: %s", errHashMismatch, m.Key())
+		}
+	}
+
+	batch = models.Merge(batch)
+	if err := h.storage.UpdateBatch(batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// verify проверяет подпись метрики, если задан ключ и подпись передана.
+func (h *Handler) verify(m models.Metrics) bool {
+	return h.key == "" || m.Hash == "" || hash.VerifyMetric(h.key, m)
+}
+
+func (h *Handler) sign(m *models.Metrics) {
+	if h.key != "" {
+		m.Hash = hash.Metric(h.key, *m)
+	}
+}
+
+// GetSeries отдаёт историю метрики. Параметры from и to принимают
+// RFC 3339 или unix-время в секундах (по умолчанию — последний час),
+// step — длительность Go или секунды.
+func (h *Handler) GetSeries(w http.ResponseWriter, r *http.Request) {
+	sr, ok := h.storage.(storage.SeriesReader)
+	if !ok {
+		http.Error(w, "series are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	vars := mux.Vars(r)
+	q := r.URL.Query()
+
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+
+	from := to.Add(-time.Hour)
+	if v := q.Get("from"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+
+	var step time.Duration
+	if v := q.Get("step"); v != "" {
+		d, err := parseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		step = d
+	}
+
+	switch vars["type"] {
+	case models.Gauge, models.Counter:
+	default:
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	labels, ok := queryLabels(r, "from", "to", "step")
+	if !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	points, ok := sr.Series(vars["type"], models.Key(vars["name"], labels), from, to, step)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if points == nil {
+		points = []storage.Point{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(points)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(sec) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
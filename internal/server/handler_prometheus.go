@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// GetMetricsPrometheus отдаёт все метрики в текстовом формате Prometheus.
+// Серии отсортированы по итоговому имени и меткам; если после санитизации
+// совпали имена разных метрик, в выдачу попадает только первая из них.
+func (h *Handler) GetMetricsPrometheus(w http.ResponseWriter, r *http.Request) {
+	type sample struct {
+		name      string
+		id        string
+		labels    string
+		mtype     string
+		value     string
+		rawLabels map[string]string
+		histogram *models.HistogramValue
+	}
+
+	gauges := h.storage.GetAllGauges()
+	counters := h.storage.GetAllCounters()
+	histograms := h.storage.GetAllHistograms()
+
+	newSample := func(key, mtype, value string) sample {
+		id, labels := models.ParseKey(key)
+		return sample{
+			name:      sanitizeMetricName(id),
+			id:        id,
+			labels:    formatLabels(labels),
+			mtype:     mtype,
+			value:     value,
+			rawLabels: labels,
+		}
+	}
+
+	samples := make([]sample, 0, len(gauges)+len(counters)+len(histograms))
+	for key, v := range gauges {
+		samples = append(samples, newSample(key, models.Gauge, strconv.FormatFloat(v, 'g', -1, 64)))
+	}
+	for key, v := range counters {
+		samples = append(samples, newSample(key, models.Counter, strconv.FormatInt(v, 10)))
+	}
+	for key, v := range histograms {
+		hv := v
+		s := newSample(key, models.Histogram, "")
+		s.histogram = &hv
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].name != samples[j].name {
+			return samples[i].name < samples[j].name
+		}
+		if samples[i].id != samples[j].id {
+			return samples[i].id < samples[j].id
+		}
+		if samples[i].mtype != samples[j].mtype {
+			return samples[i].mtype < samples[j].mtype
+		}
+		return samples[i].labels < samples[j].labels
+	})
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	owners := make(map[string]sample, len(samples))
+	for _, s := range samples {
+		if owner, ok := owners[s.name]; ok {
+			if owner.id != s.id || owner.mtype != s.mtype {
+				log.Printf("prometheus: skip %s %q: name %q already exported", s.mtype, s.id+s.labels, s.name)
+				continue
+			}
+		} else {
+			owners[s.name] = s
+			fmt.Fprintf(bw, "# TYPE %s %s\n", s.name, s.mtype)
+		}
+
+		if s.histogram != nil {
+			writeHistogram(bw, s.name, s.rawLabels, *s.histogram)
+			continue
+		}
+		fmt.Fprintf(bw, "%s%s %s\n", s.name, s.labels, s.value)
+	}
+}
+
+// writeHistogram выводит гистограмму как серии name_bucket с накопленными
+// счётчиками и меткой le, name_sum и name_count.
+func writeHistogram(w io.Writer, name string, labels map[string]string, h models.HistogramValue) {
+	bucketLabels := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		bucketLabels[k] = v
+	}
+
+	for i, c := range h.Cumulative() {
+		bucketLabels["le"] = "+Inf"
+		if i < len(h.Bounds) {
+			bucketLabels["le"] = strconv.FormatFloat(h.Bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(bucketLabels), c)
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(labels), strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(labels), h.Count)
+}
+
+// formatLabels записывает метки в виде {name="value",...} с экранированием
+// по правилам текстового формата Prometheus.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelValueEscaper.Replace(labels[name]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sanitizeMetricName приводит имя к виду [a-zA-Z_:][a-zA-Z0-9_:]*,
+// заменяя недопустимые символы на '_'.
+func sanitizeMetricName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+	b.Grow(len(name) + 1)
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -1,68 +1,45 @@
 package server
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/LemuriiL/MetricsAllerts/internal/alerting"
+	"github.com/LemuriiL/MetricsAllerts/internal/encryption"
+	"github.com/LemuriiL/MetricsAllerts/internal/hash"
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
 	"github.com/LemuriiL/MetricsAllerts/internal/storage"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-type mockStorage struct {
-	gauges   map[string]float64
-	counters map[string]int64
-}
-
-func (m *mockStorage) SetGauge(name string, value float64) {
-	m.gauges[name] = value
-}
-
-func (m *mockStorage) GetGauge(name string) (float64, bool) {
-	v, ok := m.gauges[name]
-	return v, ok
-}
-
-func (m *mockStorage) SetCounter(name string, value int64) {
-	if old, ok := m.counters[name]; ok {
-		value += old
-	}
-	m.counters[name] = value
-}
-
-func (m *mockStorage) GetCounter(name string) (int64, bool) {
-	v, ok := m.counters[name]
-	return v, ok
-}
-
-func (m *mockStorage) GetAllGauges() map[string]float64 {
-	return m.gauges
-}
-
-func (m *mockStorage) GetAllCounters() map[string]int64 {
-	return m.counters
-}
-
-func newMockStorage() storage.Storage {
-	return &mockStorage{
-		gauges:   make(map[string]float64),
-		counters: make(map[string]int64),
-	}
-}
-
 func setupRouter(handler *Handler) *mux.Router {
 	r := mux.NewRouter()
 	r.SkipClean(true)
 	r.HandleFunc("/update/{type}/{name}/{value}", handler.UpdateMetric).Methods("POST")
 	r.HandleFunc("/value/{type}/{name}", handler.GetMetricValue).Methods("GET")
+	r.HandleFunc("/value/{type}/{name}", handler.DeleteMetric).Methods("DELETE")
+	r.HandleFunc("/reset/counter/{name}", handler.ResetCounter).Methods("POST")
 	r.HandleFunc("/", handler.GetAllMetrics).Methods("GET")
+	r.HandleFunc("/update/", handler.UpdateMetricJSON).Methods("POST")
+	r.HandleFunc("/updates/", handler.UpdateMetricsJSON).Methods("POST")
+	r.HandleFunc("/value/", handler.GetMetricJSON).Methods("POST")
+	r.HandleFunc("/api/alerts", handler.GetAlerts).Methods("GET")
+	r.HandleFunc("/metrics", handler.GetMetricsPrometheus).Methods("GET")
+	r.HandleFunc("/api/series/{type}/{name}", handler.GetSeries).Methods("GET")
 	return r
 }
 
 func TestUpdateMetric(t *testing.T) {
-	store := newMockStorage()
+	store := storage.NewMemStorage()
 	handler := NewHandler(store)
 	router := setupRouter(handler)
 
@@ -100,7 +77,7 @@ func TestUpdateMetric(t *testing.T) {
 }
 
 func TestGetMetricValue(t *testing.T) {
-	store := newMockStorage()
+	store := storage.NewMemStorage()
 	store.SetGauge("mem", 1024.5)
 	store.SetCounter("calls", 42)
 
@@ -136,8 +113,101 @@ func TestGetMetricValue(t *testing.T) {
 	}
 }
 
+func TestDeleteMetric(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		remaining      []string
+	}{
+		{"gauge", "/value/gauge/mem", http.StatusOK, []string{`calls{host="a"}`, `calls{host="b"}`}},
+		{"counter by labels", "/value/counter/calls?host=b", http.StatusOK, []string{"mem", `calls{host="a"}`}},
+		{"ambiguous counter", "/value/counter/calls", http.StatusBadRequest, nil},
+		{"unknown metric", "/value/gauge/unknown", http.StatusNotFound, nil},
+		{"wrong type", "/value/counter/mem", http.StatusNotFound, nil},
+		{"invalid type", "/value/xxx/mem", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemStorage()
+			store.SetGauge("mem", 1024.5)
+			store.SetCounter(`calls{host="a"}`, 1)
+			store.SetCounter(`calls{host="b"}`, 2)
+			router := setupRouter(NewHandler(store))
+
+			req := httptest.NewRequest("DELETE", tt.url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.remaining == nil {
+				tt.remaining = []string{"mem", `calls{host="a"}`, `calls{host="b"}`}
+			}
+			var keys []string
+			for key := range store.GetAllGauges() {
+				keys = append(keys, key)
+			}
+			for key := range store.GetAllCounters() {
+				keys = append(keys, key)
+			}
+			assert.ElementsMatch(t, tt.remaining, keys)
+		})
+	}
+}
+
+func TestResetCounter(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.SetCounter("calls", 42)
+	store.SetGauge("mem", 1)
+	router := setupRouter(NewHandler(store))
+
+	reset := func(url string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", url, nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, reset("/reset/counter/calls"))
+	v, ok := store.GetCounter("calls")
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), v)
+
+	assert.Equal(t, http.StatusNotFound, reset("/reset/counter/mem"))
+	assert.Equal(t, http.StatusNotFound, reset("/reset/counter/unknown"))
+	assert.Equal(t, http.StatusBadRequest, reset("/reset/counter/calls?bad-label=1"))
+}
+
+// brokenStorage читает метрики, но не может их изменить.
+type brokenStorage struct {
+	*storage.MemStorage
+}
+
+func (brokenStorage) Delete(mtype, name string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (brokenStorage) ResetCounter(name string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestStorageErrorIsNotNotFound(t *testing.T) {
+	store := brokenStorage{storage.NewMemStorage()}
+	store.SetCounter("calls", 1)
+	router := setupRouter(NewHandler(store))
+
+	do := func(method, url string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, do("DELETE", "/value/counter/calls"))
+	assert.Equal(t, http.StatusInternalServerError, do("POST", "/reset/counter/calls"))
+}
+
 func TestGetAllMetrics(t *testing.T) {
-	store := newMockStorage()
+	store := storage.NewMemStorage()
 	store.SetGauge("temp", 36.6)
 	store.SetCounter("hits", 100)
 
@@ -156,3 +226,550 @@ func TestGetAllMetrics(t *testing.T) {
 	assert.Contains(t, body, "temp: 36.6")
 	assert.Contains(t, body, "hits: 100")
 }
+
+func TestMetricLabels(t *testing.T) {
+	store := storage.NewMemStorage()
+	router := setupRouter(NewHandler(store))
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do("POST", "/update/gauge/Alloc/1?host=a", "").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/update/gauge/Alloc/2?host=b", "").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/update/counter/PollCount/5", "").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/update/", `{"id":"PollCount","type":"counter","delta":3,"labels":{"host":"a"}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/update/gauge/Alloc/1?bad-label=x", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/update/", `{"id":"X","type":"gauge","value":1,"labels":{"1x":"a"}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", `/update/gauge/Alloc{host="a"}/7`, "").Code, "id must not forge a labelled key")
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/update/", `{"id":"Alloc{host=\"a\"}","type":"gauge","value":7}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/updates/", `[{"id":"Alloc{host=\"a\"}","type":"gauge","value":7}]`).Code)
+
+	v, ok := store.GetGauge(`Alloc{host="a"}`)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, v)
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"exact labels", "/value/gauge/Alloc?host=b", http.StatusOK, "2"},
+		{"ambiguous", "/value/gauge/Alloc", http.StatusBadRequest, ""},
+		{"unknown label value", "/value/gauge/Alloc?host=c", http.StatusNotFound, ""},
+		{"label-less metric", "/value/counter/PollCount", http.StatusOK, "5"},
+		{"labelled counter", "/value/counter/PollCount?host=a", http.StatusOK, "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do("GET", tt.url, "")
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, strings.TrimSuffix(w.Body.String(), "\n"))
+			}
+		})
+	}
+
+	w := do("POST", "/value/", `{"id":"Alloc","type":"gauge","labels":{"host":"a"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var m models.Metrics
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+	assert.Equal(t, 1.0, *m.Value)
+	assert.Equal(t, map[string]string{"host": "a"}, m.Labels)
+
+	body := do("GET", "/?host=b", "").Body.String()
+	assert.Contains(t, body, "Alloc{host=&#34;b&#34;}: 2")
+	assert.NotContains(t, body, "host=&#34;a&#34;")
+	assert.NotContains(t, body, "PollCount: 5")
+}
+
+func TestHistogramMetrics(t *testing.T) {
+	store := storage.NewMemStorage()
+	router := setupRouter(NewHandler(store))
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do("POST", "/update/", `{"id":"Latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[1,2,0],"sum":1.2,"count":3}}`).Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/updates/", `[{"id":"Latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[0,0,1],"sum":5,"count":1}}]`).Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/update/histogram/Latency/0.05", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/update/", `{"id":"Latency","type":"histogram","histogram":{"bounds":[1],"counts":[1],"count":1}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/update/", `{"id":"Latency","type":"histogram"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/update/histogram/Latency/abc", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/update/histogram/Latency/Inf", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/update/histogram/Latency/-Inf", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/update/", `{"id":"Latency","type":"histogram","histogram":{"bounds":[1],"counts":[0,1],"count":1,"sum":1e309}}`).Code)
+
+	w := do("GET", "/value/histogram/Latency", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "count=5 sum=6.25 buckets=[0.1:2 1:4 +Inf:5]", w.Body.String())
+
+	w = do("POST", "/value/", `{"id":"Latency","type":"histogram"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var m models.Metrics
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+	require.NotNil(t, m.Histogram)
+	assert.Equal(t, []uint64{2, 2, 1}, m.Histogram.Counts)
+
+	assert.Equal(t, http.StatusOK, do("POST", "/update/histogram/Fresh/0.002", "").Code)
+	v, ok := store.GetHistogram("Fresh")
+	require.True(t, ok)
+	assert.Equal(t, models.DefaultBuckets, v.Bounds)
+
+	assert.Contains(t, do("GET", "/", "").Body.String(), "Latency: count=5")
+}
+
+func TestUpdateMetricsJSON(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            string
+		expectedStatus  int
+		expectedGauge   float64
+		expectedCounter int64
+	}{
+		{
+			"valid batch",
+			`[{"id":"g","type":"gauge","value":1.5},{"id":"c","type":"counter","delta":3}]`,
+			http.StatusOK, 1.5, 3,
+		},
+		{
+			"repeated counters are summed",
+			`[{"id":"c","type":"counter","delta":3},{"id":"g","type":"gauge","value":1},{"id":"c","type":"counter","delta":4},{"id":"g","type":"gauge","value":2}]`,
+			http.StatusOK, 2, 7,
+		},
+		{
+			"invalid metric rejects whole batch",
+			`[{"id":"c","type":"counter","delta":3},{"id":"g","type":"gauge"}]`,
+			http.StatusBadRequest, 0, 0,
+		},
+		{
+			"unknown type",
+			`[{"id":"x","type":"xxx","value":1}]`,
+			http.StatusBadRequest, 0, 0,
+		},
+		{
+			"malformed json",
+			`[{"id":`,
+			http.StatusBadRequest, 0, 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemStorage()
+			router := setupRouter(NewHandler(store))
+
+			req := httptest.NewRequest("POST", "/updates/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				assert.Empty(t, store.GetAllGauges())
+				assert.Empty(t, store.GetAllCounters())
+				return
+			}
+
+			g, ok := store.GetGauge("g")
+			assert.True(t, ok)
+			assert.Equal(t, tt.expectedGauge, g)
+			c, ok := store.GetCounter("c")
+			assert.True(t, ok)
+			assert.Equal(t, tt.expectedCounter, c)
+		})
+	}
+}
+
+func TestGetAlerts(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.SetGauge("HeapAlloc", 1024)
+
+	rule, err := alerting.ParseRule("gauge HeapAlloc > 1KB")
+	assert.NoError(t, err)
+
+	handler := NewHandler(store)
+	router := setupRouter(handler)
+
+	req := httptest.NewRequest("GET", "/api/alerts", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	store.SetGauge("HeapAlloc", 2048)
+	handler.alerts = alerting.NewEngine(store, []alerting.Rule{rule}, nil)
+	handler.alerts.Evaluate()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+
+	var alerts []alerting.Alert
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &alerts))
+	if assert.Len(t, alerts, 1) {
+		assert.Equal(t, alerting.StateFiring, alerts[0].State)
+		assert.Equal(t, "HeapAlloc", alerts[0].MetricID)
+	}
+}
+
+func TestGetMetricsPrometheus(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.SetGauge("b.gauge", 1.5)
+	store.SetGauge("HeapAlloc", 1024)
+	store.SetGauge("9lives", 9)
+	store.SetCounter("PollCount", 7)
+	store.SetCounter("b-gauge", 1)
+
+	router := setupRouter(NewHandler(store))
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, prometheusContentType, w.Header().Get("Content-Type"))
+
+	expected := "# TYPE HeapAlloc gauge\n" +
+		"HeapAlloc 1024\n" +
+		"# TYPE PollCount counter\n" +
+		"PollCount 7\n" +
+		"# TYPE _9lives gauge\n" +
+		"_9lives 9\n" +
+		"# TYPE b_gauge counter\n" +
+		"b_gauge 1\n"
+	assert.Equal(t, expected, w.Body.String())
+}
+
+func TestGetMetricsPrometheusLabels(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.SetGauge(models.Key("Alloc", map[string]string{"host": "b"}), 2)
+	store.SetGauge(models.Key("Alloc", map[string]string{"host": "a\"\\\n"}), 1)
+	store.SetGauge("Alloc", 3)
+
+	router := setupRouter(NewHandler(store))
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	expected := "# TYPE Alloc gauge\n" +
+		"Alloc 3\n" +
+		`Alloc{host="a\"\\\n"} 1` + "\n" +
+		`Alloc{host="b"} 2` + "\n"
+	assert.Equal(t, expected, w.Body.String())
+}
+
+func TestGetMetricsPrometheusHistogram(t *testing.T) {
+	store := storage.NewMemStorage()
+	h := models.NewHistogram([]float64{0.5, 1})
+	h.Observe(0.2)
+	h.Observe(0.7)
+	h.Observe(3)
+	store.SetHistogram(models.Key("Latency", map[string]string{"host": "a"}), *h)
+
+	router := setupRouter(NewHandler(store))
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	expected := "# TYPE Latency histogram\n" +
+		`Latency_bucket{host="a",le="0.5"} 1` + "\n" +
+		`Latency_bucket{host="a",le="1"} 2` + "\n" +
+		`Latency_bucket{host="a",le="+Inf"} 3` + "\n" +
+		`Latency_sum{host="a"} 3.9` + "\n" +
+		`Latency_count{host="a"} 3` + "\n"
+	assert.Equal(t, expected, w.Body.String())
+}
+
+func TestHashMiddleware(t *testing.T) {
+	const key = "secret"
+	body := `[{"id":"g","type":"gauge","value":1.5}]`
+
+	tests := []struct {
+		name           string
+		body           string
+		sum            string
+		expectedStatus int
+	}{
+		{"valid signature", body, hash.Sign(key, []byte(body)), http.StatusOK},
+		{"tampered body", strings.Replace(body, "1.5", "9.5", 1), hash.Sign(key, []byte(body)), http.StatusBadRequest},
+		{"key mismatch", body, hash.Sign("other", []byte(body)), http.StatusBadRequest},
+		{"missing signature", body, "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemStorage()
+			handler := NewHandler(store)
+			handler.key = key
+			router := setupRouter(handler)
+			router.Use(hashMiddleware(key))
+
+			req := httptest.NewRequest("POST", "/updates/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.sum != "" {
+				req.Header.Set(hash.Header, tt.sum)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				assert.Empty(t, store.GetAllGauges())
+				return
+			}
+			assert.True(t, hash.Verify(key, w.Body.Bytes(), w.Header().Get(hash.Header)))
+
+			var resp []models.Metrics
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			if assert.Len(t, resp, 1) {
+				assert.True(t, hash.VerifyMetric(key, resp[0]))
+			}
+		})
+	}
+}
+
+func TestHashMiddlewareDelete(t *testing.T) {
+	const key = "secret"
+	store := storage.NewMemStorage()
+	store.SetGauge("g", 1)
+	router := New(store, WithKey(key)).Router()
+
+	req := httptest.NewRequest("DELETE", "/value/gauge/g", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	do := func(method, url, sum string) int {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set(hash.Header, sum)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	sign := func(method, uri string) string {
+		return hash.Sign(key, hash.RequestData(method, uri))
+	}
+
+	store.SetGauge("h", 2)
+	captured := sign("DELETE", "/value/gauge/g")
+	assert.Equal(t, http.StatusBadRequest, do("DELETE", "/value/gauge/h", captured), "signature is bound to the path")
+	assert.Equal(t, http.StatusBadRequest, do("DELETE", "/value/gauge/h", hash.Sign(key, nil)), "empty body signature is refused")
+	assert.Equal(t, http.StatusOK, do("DELETE", "/value/gauge/g", captured))
+	assert.Equal(t, map[string]float64{"h": 2}, store.GetAllGauges())
+
+	store.SetCounter("c", 3)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/reset/counter/c", sign("POST", "/reset/counter/d")))
+	assert.Equal(t, http.StatusOK, do("POST", "/reset/counter/c", sign("POST", "/reset/counter/c")))
+
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/update/gauge/h/9", sign("POST", "/update/gauge/h/1")))
+	assert.Equal(t, http.StatusOK, do("POST", "/update/gauge/h/9", sign("POST", "/update/gauge/h/9")))
+	v, _ := store.GetGauge("h")
+	assert.Equal(t, 9.0, v)
+}
+
+func TestDecryptMiddleware(t *testing.T) {
+	priv, err := encryption.GenerateKey(2048)
+	require.NoError(t, err)
+
+	body := `[{"id":"g","type":"gauge","value":1.5}]`
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(body))
+	require.NoError(t, zw.Close())
+
+	encrypted, err := encryption.Encrypt(&priv.PublicKey, gz.Bytes())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		body           []byte
+		scheme         string
+		gzip           bool
+		expectedStatus int
+	}{
+		{"encrypted gzip body", encrypted, encryption.Scheme, true, http.StatusOK},
+		{"plain body", []byte(body), "", false, http.StatusBadRequest},
+		{"corrupted body", encrypted[:len(encrypted)-1], encryption.Scheme, true, http.StatusBadRequest},
+		{"unknown scheme", encrypted, "rot13", true, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemStorage()
+			router := New(store, WithCryptoKey(priv)).Router()
+
+			req := httptest.NewRequest("POST", "/updates/", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.scheme != "" {
+				req.Header.Set(encryption.Header, tt.scheme)
+			}
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			_, ok := store.GetGauge("g")
+			assert.Equal(t, tt.expectedStatus == http.StatusOK, ok)
+		})
+	}
+
+	t.Run("plain reads", func(t *testing.T) {
+		store := storage.NewMemStorage()
+		store.SetGauge("g", 1)
+		router := New(store, WithCryptoKey(priv)).Router()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/value/gauge/g", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/update/gauge/g/2", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		v, _ := store.GetGauge("g")
+		assert.Equal(t, 1.0, v)
+	})
+}
+
+func TestTrustedSubnet(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		realIP         string
+		expectedStatus int
+	}{
+		{"update from subnet", "POST", "/update/gauge/g/1", "192.168.1.10", http.StatusOK},
+		{"update outside subnet", "POST", "/update/gauge/g/1", "10.0.0.1", http.StatusForbidden},
+		{"update without header", "POST", "/update/gauge/g/1", "", http.StatusForbidden},
+		{"update with garbage", "POST", "/update/gauge/g/1", "localhost", http.StatusForbidden},
+		{"batch outside subnet", "POST", "/updates/", "10.0.0.1", http.StatusForbidden},
+		{"delete outside subnet", "DELETE", "/value/gauge/g", "10.0.0.1", http.StatusForbidden},
+		{"delete from subnet", "DELETE", "/value/gauge/g", "192.168.1.10", http.StatusOK},
+		{"reset outside subnet", "POST", "/reset/counter/c", "10.0.0.1", http.StatusForbidden},
+		{"reset from subnet", "POST", "/reset/counter/c", "192.168.1.10", http.StatusOK},
+		{"read outside subnet", "GET", "/value/gauge/g", "10.0.0.1", http.StatusOK},
+		{"list without header", "GET", "/", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemStorage()
+			store.SetGauge("g", 0)
+			store.SetCounter("c", 1)
+			router := New(store, WithTrustedSubnet(subnet)).Router()
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`[{"id":"g","type":"gauge","value":1}]`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.realIP != "" {
+				req.Header.Set(RealIPHeader, tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestUpdateMetricsJSONMetricHash(t *testing.T) {
+	const key = "secret"
+	val := 1.5
+	m := models.Metrics{ID: "g", MType: models.Gauge, Value: &val}
+
+	tests := []struct {
+		name           string
+		hash           string
+		expectedStatus int
+	}{
+		{"valid metric hash", hash.Metric(key, m), http.StatusOK},
+		{"wrong metric hash", hash.Metric("other", m), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(storage.NewMemStorage())
+			handler.key = key
+			router := setupRouter(handler)
+
+			signed := m
+			signed.Hash = tt.hash
+			body, err := json.Marshal([]models.Metrics{signed})
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest("POST", "/updates/", strings.NewReader(string(body)))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestGetSeries(t *testing.T) {
+	series := storage.NewTimeSeriesStorage(storage.NewMemStorage(), storage.DefaultRetention)
+	series.SetGauge("temp", 36.6)
+	series.SetGauge("temp", 37.2)
+
+	router := setupRouter(NewHandler(series))
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedPoints int
+	}{
+		{"raw points", "/api/series/gauge/temp", http.StatusOK, 2},
+		{"downsampled", "/api/series/gauge/temp?step=1h", http.StatusOK, 1},
+		{"empty range", "/api/series/gauge/temp?from=0&to=1", http.StatusOK, 0},
+		{"unknown metric", "/api/series/gauge/unknown", http.StatusNotFound, 0},
+		{"invalid type", "/api/series/xxx/temp", http.StatusBadRequest, 0},
+		{"invalid step", "/api/series/gauge/temp?step=soon", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var points []storage.Point
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &points))
+			assert.Len(t, points, tt.expectedPoints)
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/api/series/gauge/temp", nil)
+	w := httptest.NewRecorder()
+	setupRouter(NewHandler(storage.NewMemStorage())).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/hash"
+)
+
+type hashResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *hashResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *hashResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(p)
+}
+
+func (w *hashResponseWriter) flush(key string) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.Header().Set(hash.Header, hash.Sign(key, w.buf.Bytes()))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.buf.Bytes())
+}
+
+// hashMiddleware проверяет подпись тела POST- и DELETE-запросов
+// и подписывает ответы. У запросов без тела подписываются метод и URI.
+// Тело запроса должно быть уже распаковано, поэтому middleware
+// подключается после gzipMiddleware.
+func hashMiddleware(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					http.Error(w, "bad request", http.StatusBadRequest)
+					return
+				}
+				signed := body
+				if len(body) == 0 {
+					signed = hash.RequestData(r.Method, r.URL.RequestURI())
+				}
+				if !hash.Verify(key, signed, r.Header.Get(hash.Header)) {
+					http.Error(w, "hash mismatch", http.StatusBadRequest)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+
+			hw := &hashResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(hw, r)
+			hw.flush(key)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+const pingTimeout = 2 * time.Second
+
+// Ping отвечает 200, если хранилище доступно, и 500 в противном случае.
+func (h *Handler) Ping(w http.ResponseWriter, r *http.Request) {
+	if err := h.ping(r.Context()); err != nil {
+		log.Printf("ping: storage unavailable: %v", err)
+		http.Error(w, "storage unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeText(w, "ok")
+}
+
+// Healthz — проверка живости: сервер отвечает на запросы.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeText(w, "ok")
+}
+
+// Readyz отвечает 503, пока не завершено восстановление метрик
+// или хранилище недоступно.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if !h.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	if err := h.ping(r.Context()); err != nil {
+		log.Printf("readyz: storage unavailable: %v", err)
+		http.Error(w, "storage unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	writeText(w, "ok")
+}
+
+func (h *Handler) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return h.storage.Ping(ctx)
+}
+
+func writeText(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(body))
+}
+
+// readyMiddleware отклоняет изменение метрик с 503, пока сервер
+// не готов: запись до восстановления из файла затёрла бы данные.
+func readyMiddleware(h *Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isWrite(r) && !h.ready.Load() {
+				http.Error(w, "not ready", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,37 +1,137 @@
 package server
 
 import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/LemuriiL/MetricsAllerts/internal/alerting"
 	"github.com/LemuriiL/MetricsAllerts/internal/storage"
 	"github.com/gorilla/mux"
 )
 
+const shutdownTimeout = 10 * time.Second
+
 type Server struct {
-	handler *Handler
+	handler       *Handler
+	privateKey    *rsa.PrivateKey
+	trustedSubnet *net.IPNet
+}
+
+type Option func(*Server)
+
+func WithAlerts(engine *alerting.Engine) Option {
+	return func(s *Server) {
+		s.handler.alerts = engine
+	}
+}
+
+// WithKey включает проверку и выдачу HMAC-SHA256 подписей.
+func WithKey(key string) Option {
+	return func(s *Server) {
+		s.handler.key = key
+	}
 }
 
-func New(storage storage.Storage) *Server {
-	return &Server{
+// WithCryptoKey включает расшифровку тел запросов закрытым ключом.
+func WithCryptoKey(key *rsa.PrivateKey) Option {
+	return func(s *Server) {
+		s.privateKey = key
+	}
+}
+
+// WithTrustedSubnet разрешает запись метрик только агентам из subnet.
+func WithTrustedSubnet(subnet *net.IPNet) Option {
+	return func(s *Server) {
+		s.trustedSubnet = subnet
+	}
+}
+
+func New(storage storage.Storage, opts ...Option) *Server {
+	s := &Server{
 		handler: NewHandler(storage),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SetReady отмечает готовность сервера. Пока сервер не готов, /readyz
+// отвечает 503, а запись метрик по HTTP и gRPC отклоняется.
+func (s *Server) SetReady(ready bool) {
+	s.handler.ready.Store(ready)
+}
+
+// Run слушает addr до отмены ctx, после чего дожидается завершения
+// обрабатываемых запросов.
+func (s *Server) Run(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{Handler: s.Router()}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
-func (s *Server) Run(addr string) error {
+// Router возвращает маршрутизатор со всеми middleware.
+func (s *Server) Router() http.Handler {
 	r := mux.NewRouter()
 	r.SkipClean(true)
 
 	r.Use(loggingMiddleware)
 	r.Use(loggingMiddleware)
+	r.Use(subnetMiddleware(s.trustedSubnet))
+	r.Use(readyMiddleware(s.handler))
+	r.Use(decryptMiddleware(s.privateKey))
 	r.Use(gzipMiddleware)
+	r.Use(hashMiddleware(s.handler.key))
 
+	r.HandleFunc("/ping", s.handler.Ping).Methods("GET")
+	r.HandleFunc("/healthz", s.handler.Healthz).Methods("GET")
+	r.HandleFunc("/readyz", s.handler.Readyz).Methods("GET")
 	r.HandleFunc("/update/{type}/{name}/{value}", s.handler.UpdateMetric).Methods("POST")
 	r.HandleFunc("/value/{type}/{name}", s.handler.GetMetricValue).Methods("GET")
+	r.HandleFunc("/value/{type}/{name}", s.handler.DeleteMetric).Methods("DELETE")
+	r.HandleFunc("/reset/counter/{name}", s.handler.ResetCounter).Methods("POST")
 	r.HandleFunc("/", s.handler.GetAllMetrics).Methods("GET")
 	r.HandleFunc("/update", s.handler.UpdateMetricJSON).Methods("POST")
 	r.HandleFunc("/update/", s.handler.UpdateMetricJSON).Methods("POST")
+	r.HandleFunc("/updates", s.handler.UpdateMetricsJSON).Methods("POST")
+	r.HandleFunc("/updates/", s.handler.UpdateMetricsJSON).Methods("POST")
 	r.HandleFunc("/value", s.handler.GetMetricJSON).Methods("POST")
 	r.HandleFunc("/value/", s.handler.GetMetricJSON).Methods("POST")
+	r.HandleFunc("/api/alerts", s.handler.GetAlerts).Methods("GET")
+	r.HandleFunc("/metrics", s.handler.GetMetricsPrometheus).Methods("GET")
+	r.HandleFunc("/api/series/{type}/{name}", s.handler.GetSeries).Methods("GET")
 
-	return http.ListenAndServe(addr, r)
+	return r
 }
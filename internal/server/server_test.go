@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type slowStorage struct {
+	storage.Storage
+	delay time.Duration
+}
+
+func (s *slowStorage) GetAllGauges() map[string]float64 {
+	time.Sleep(s.delay)
+	return s.Storage.GetAllGauges()
+}
+
+func TestServerServeDrainsOnShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := New(&slowStorage{Storage: storage.NewMemStorage(), delay: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ctx, ln)
+	}()
+
+	respCh := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			respCh <- 0
+			return
+		}
+		resp.Body.Close()
+		respCh <- resp.StatusCode
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-serveErr:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	assert.Equal(t, http.StatusOK, <-respCh)
+
+	_, err = http.Get("http://" + ln.Addr().String() + "/")
+	assert.Error(t, err)
+}
+
+type downStorage struct {
+	storage.Storage
+}
+
+func (downStorage) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	get := func(h http.Handler, method, url string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		return w.Code
+	}
+
+	srv := New(storage.NewMemStorage())
+	router := srv.Router()
+	assert.Equal(t, http.StatusOK, get(router, "GET", "/ping"))
+	assert.Equal(t, http.StatusOK, get(router, "GET", "/healthz"))
+	assert.Equal(t, http.StatusOK, get(router, "GET", "/readyz"))
+
+	srv.SetReady(false)
+	assert.Equal(t, http.StatusServiceUnavailable, get(router, "GET", "/readyz"))
+	assert.Equal(t, http.StatusOK, get(router, "GET", "/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, get(router, "POST", "/update/gauge/g/1"), "writes wait for restore")
+	assert.Equal(t, http.StatusNotFound, get(router, "GET", "/value/gauge/g"), "reads are served")
+
+	srv.SetReady(true)
+	assert.Equal(t, http.StatusOK, get(router, "POST", "/update/gauge/g/1"))
+
+	down := New(downStorage{storage.NewMemStorage()}).Router()
+	assert.Equal(t, http.StatusInternalServerError, get(down, "GET", "/ping"))
+	assert.Equal(t, http.StatusServiceUnavailable, get(down, "GET", "/readyz"))
+	assert.Equal(t, http.StatusOK, get(down, "GET", "/healthz"))
+}
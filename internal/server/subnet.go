@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RealIPHeader — заголовок, в котором агент передаёт свой адрес.
+const RealIPHeader = "X-Real-IP"
+
+// realIPMetadata — тот же адрес в метаданных gRPC-вызова.
+const realIPMetadata = "x-real-ip"
+
+// trusted сообщает, входит ли адрес ip в подсеть. Пустая подсеть
+// разрешает любые адреса.
+func trusted(subnet *net.IPNet, ip string) bool {
+	if subnet == nil {
+		return true
+	}
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	return parsed != nil && subnet.Contains(parsed)
+}
+
+// isWrite сообщает, изменяет ли запрос метрики: запись (/update,
+// /updates), сброс счётчика (/reset) и удаление.
+func isWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodDelete:
+		return true
+	case http.MethodPost:
+		return strings.HasPrefix(r.URL.Path, "/update") || strings.HasPrefix(r.URL.Path, "/reset/")
+	}
+	return false
+}
+
+// subnetMiddleware отклоняет с 403 изменение метрик от агентов,
+// чей X-Real-IP не входит в subnet. Чтение не ограничивается.
+func subnetMiddleware(subnet *net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isWrite(r) && !trusted(subnet, r.Header.Get(RealIPHeader)) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func trustedCall(ctx context.Context, subnet *net.IPNet) error {
+	if subnet == nil {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, ip := range md.Get(realIPMetadata) {
+		if trusted(subnet, ip) {
+			return nil
+		}
+	}
+	return status.Error(codes.PermissionDenied, "forbidden")
+}
@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+var (
+	gaugesBucket     = []byte("gauges")
+	countersBucket   = []byte("counters")
+	histogramsBucket = []byte("histograms")
+)
+
+// ErrNotEmpty возвращает ImportFile, если в базе уже есть метрики.
+var ErrNotEmpty = errors.New("storage is not empty")
+
+// BoltStorage хранит метрики во встроенной базе bbolt: каждый тип
+// в своём бакете, ключ — ключ серии. Каждое изменение — отдельная
+// транзакция, зафиксированная на диске до возврата.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltStorage открывает или создаёт базу в path. Базу может держать
+// открытой только один процесс; второй получит ошибку через секунду.
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	if dir := filepath.Dir(path); dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("bolt: open %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{gaugesBucket, countersBucket, histogramsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage{db: db}, nil
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+// Ping проверяет, что база открыта и принимает пишущие транзакции.
+func (s *BoltStorage) Ping(ctx context.Context) error {
+	return s.db.Update(func(tx *bolt.Tx) error { return nil })
+}
+
+func (s *BoltStorage) SetGauge(name string, value float64) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putGauge(tx, name, value)
+	})
+	if err != nil {
+		log.Printf("bolt: set gauge %s: %v", name, err)
+	}
+}
+
+func (s *BoltStorage) GetGauge(name string) (float64, bool) {
+	var (
+		val float64
+		ok  bool
+	)
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(gaugesBucket).Get([]byte(name)); v != nil {
+			val, ok = decodeGauge(v), true
+		}
+		return nil
+	})
+	return val, ok
+}
+
+// SetCounter прибавляет value к счётчику. Чтение и запись идут в одной
+// транзакции, поэтому одновременные приращения не теряются.
+func (s *BoltStorage) SetCounter(name string, value int64) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return addCounter(tx, name, value)
+	})
+	if err != nil {
+		log.Printf("bolt: set counter %s: %v", name, err)
+	}
+}
+
+func (s *BoltStorage) GetCounter(name string) (int64, bool) {
+	var (
+		val int64
+		ok  bool
+	)
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(countersBucket).Get([]byte(name)); v != nil {
+			val, ok = decodeCounter(v), true
+		}
+		return nil
+	})
+	return val, ok
+}
+
+func (s *BoltStorage) GetAllGauges() map[string]float64 {
+	res := make(map[string]float64)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(gaugesBucket).ForEach(func(k, v []byte) error {
+			res[string(k)] = decodeGauge(v)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("bolt: get all gauges: %v", err)
+	}
+	return res
+}
+
+func (s *BoltStorage) GetAllCounters() map[string]int64 {
+	res := make(map[string]int64)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(countersBucket).ForEach(func(k, v []byte) error {
+			res[string(k)] = decodeCounter(v)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("bolt: get all counters: %v", err)
+	}
+	return res
+}
+
+func (s *BoltStorage) SetHistogram(name string, value models.HistogramValue) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return mergeBoltHistogram(tx, name, value)
+	})
+	if err != nil {
+		log.Printf("bolt: set histogram %s: %v", name, err)
+	}
+}
+
+func (s *BoltStorage) GetHistogram(name string) (models.HistogramValue, bool) {
+	var (
+		val models.HistogramValue
+		ok  bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(histogramsBucket).Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		if err := json.Unmarshal(v, &val); err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	if err != nil {
+		log.Printf("bolt: get histogram %s: %v", name, err)
+		return models.HistogramValue{}, false
+	}
+	return val, ok
+}
+
+func (s *BoltStorage) GetAllHistograms() map[string]models.HistogramValue {
+	res := make(map[string]models.HistogramValue)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(histogramsBucket).ForEach(func(k, v []byte) error {
+			var h models.HistogramValue
+			if err := json.Unmarshal(v, &h); err != nil {
+				log.Printf("bolt: decode histogram %s: %v", k, err)
+				return nil
+			}
+			res[string(k)] = h
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("bolt: get all histograms: %v", err)
+	}
+	return res
+}
+
+// UpdateBatch применяет пакет в одной транзакции: либо весь, либо никак.
+func (s *BoltStorage) UpdateBatch(metrics []models.Metrics) error {
+	if err := ValidateBatch(metrics); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return applyBatch(tx, metrics)
+	})
+}
+
+func (s *BoltStorage) Delete(mtype, name string) (bool, error) {
+	var bucket []byte
+	switch mtype {
+	case models.Gauge:
+		bucket = gaugesBucket
+	case models.Counter:
+		bucket = countersBucket
+	case models.Histogram:
+		bucket = histogramsBucket
+	default:
+		return false, nil
+	}
+
+	var ok bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b.Get([]byte(name)) == nil {
+			return nil
+		}
+		ok = true
+		return b.Delete([]byte(name))
+	})
+	if err != nil {
+		return false, fmt.Errorf("bolt: delete %s %s: %w", mtype, name, err)
+	}
+	return ok, nil
+}
+
+func (s *BoltStorage) ResetCounter(name string) (bool, error) {
+	var ok bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(countersBucket)
+		if b.Get([]byte(name)) == nil {
+			return nil
+		}
+		ok = true
+		return b.Put([]byte(name), encodeCounter(0))
+	})
+	if err != nil {
+		return false, fmt.Errorf("bolt: reset counter %s: %w", name, err)
+	}
+	return ok, nil
+}
+
+// ImportFile переносит в пустую базу метрики FileStorage из path:
+// снимок вместе с журналом. Импорт идёт одной транзакцией. Если в базе
+// уже есть метрики, возвращается ErrNotEmpty и ничего не меняется,
+// поэтому повторный импорт при каждом запуске безопасен.
+func (s *BoltStorage) ImportFile(path string) (int, error) {
+	src := NewFileStorage(path, false)
+	if err := src.Restore(); err != nil {
+		return 0, fmt.Errorf("bolt: import %s: %w", path, err)
+	}
+	metrics := src.snapshot()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{gaugesBucket, countersBucket, histogramsBucket} {
+			if k, _ := tx.Bucket(name).Cursor().First(); k != nil {
+				return ErrNotEmpty
+			}
+		}
+		return applyBatch(tx, metrics)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(metrics), nil
+}
+
+func applyBatch(tx *bolt.Tx, metrics []models.Metrics) error {
+	for _, m := range metrics {
+		var err error
+		switch m.MType {
+		case models.Gauge:
+			err = putGauge(tx, m.Key(), *m.Value)
+		case models.Counter:
+			err = addCounter(tx, m.Key(), *m.Delta)
+		case models.Histogram:
+			err = mergeBoltHistogram(tx, m.Key(), *m.Histogram)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putGauge(tx *bolt.Tx, name string, value float64) error {
+	return tx.Bucket(gaugesBucket).Put([]byte(name), encodeGauge(value))
+}
+
+func addCounter(tx *bolt.Tx, name string, delta int64) error {
+	b := tx.Bucket(countersBucket)
+	if v := b.Get([]byte(name)); v != nil {
+		delta += decodeCounter(v)
+	}
+	return b.Put([]byte(name), encodeCounter(delta))
+}
+
+func mergeBoltHistogram(tx *bolt.Tx, name string, delta models.HistogramValue) error {
+	b := tx.Bucket(histogramsBucket)
+	var (
+		old    models.HistogramValue
+		exists bool
+	)
+	if v := b.Get([]byte(name)); v != nil {
+		exists = json.Unmarshal(v, &old) == nil
+	}
+	data, err := json.Marshal(mergeHistogram(old, exists, delta))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(name), data)
+}
+
+// Значения gauge и counter хранятся как 8 байт big-endian.
+
+func encodeGauge(v float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+}
+
+func decodeGauge(b []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(b))
+}
+
+func encodeCounter(v int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(v))
+}
+
+func decodeCounter(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltStorageReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	s, err := NewBoltStorage(path)
+	require.NoError(t, err)
+
+	key := models.Key("Alloc", map[string]string{"host": "a"})
+	h := models.NewHistogram([]float64{1})
+	h.Observe(2)
+	s.SetGauge(key, 1.5)
+	s.SetCounter("PollCount", 2)
+	s.SetCounter("PollCount", 3)
+	s.SetHistogram("GCPause", *h)
+
+	_, err = NewBoltStorage(path)
+	assert.Error(t, err, "database is locked while open")
+	require.NoError(t, s.Close())
+
+	s, err = NewBoltStorage(path)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, map[string]float64{key: 1.5}, s.GetAllGauges())
+	assert.Equal(t, map[string]int64{"PollCount": 5}, s.GetAllCounters())
+	assert.Equal(t, map[string]models.HistogramValue{"GCPause": *h}, s.GetAllHistograms())
+}
+
+func TestBoltStorageImportFile(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "metrics-db.json")
+
+	src := NewFileStorage(jsonPath, true)
+	key := models.Key("Alloc", map[string]string{"host": "a"})
+	src.SetGauge(key, 1)
+	src.SetCounter("PollCount", 4)
+	require.NoError(t, src.Save())
+	// Запись после снимка остаётся только в журнале и тоже импортируется.
+	src.SetCounter("PollCount", 1)
+	require.NoError(t, src.Close())
+
+	s, err := NewBoltStorage(filepath.Join(dir, "metrics.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	n, err := s.ImportFile(jsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, map[string]float64{key: 1}, s.GetAllGauges())
+	assert.Equal(t, map[string]int64{"PollCount": 5}, s.GetAllCounters())
+
+	_, err = s.ImportFile(jsonPath)
+	assert.ErrorIs(t, err, ErrNotEmpty)
+	assert.Equal(t, map[string]int64{"PollCount": 5}, s.GetAllCounters(), "second import changes nothing")
+
+	empty, err := NewBoltStorage(filepath.Join(dir, "empty.db"))
+	require.NoError(t, err)
+	defer empty.Close()
+	n, err = empty.ImportFile(filepath.Join(dir, "missing.json"))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
@@ -0,0 +1,71 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewMemStorage()
+	})
+}
+
+func TestFileStorageConformance(t *testing.T) {
+	for _, syncWrite := range []bool{false, true} {
+		syncWrite := syncWrite
+		name := "interval"
+		if syncWrite {
+			name = "sync"
+		}
+		t.Run(name, func(t *testing.T) {
+			storagetest.Run(t, func(t *testing.T) storage.Storage {
+				s := storage.NewFileStorage(filepath.Join(t.TempDir(), "metrics.json"), syncWrite)
+				t.Cleanup(func() { s.Close() })
+				return s
+			})
+		})
+	}
+}
+
+func TestBoltStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := storage.NewBoltStorage(filepath.Join(t.TempDir(), "metrics.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestTimeSeriesStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewTimeSeriesStorage(storage.NewMemStorage(), storage.DefaultRetention)
+	})
+}
+
+func TestPostgresStorageConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := storage.NewPostgresStorage(context.Background(), dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		db, err := sql.Open("pgx", dsn)
+		require.NoError(t, err)
+		defer db.Close()
+		_, err = db.Exec(`TRUNCATE gauges, counters, histograms`)
+		require.NoError(t, err)
+		return s
+	})
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Open создаёт хранилище по адресу dsn. Схема адреса выбирает бэкенд:
+//
+//	mem://                     — в памяти, без сохранения;
+//	file:///abs/path.json      — файл (относительный путь: file:data/metrics.json);
+//	bolt:///abs/path.db        — встроенная база bbolt, путь как у file;
+//	postgres://user@host/db    — PostgreSQL (также postgresql://
+//	                             и строка вида "host=... dbname=...").
+//
+// Файловое хранилище создаётся без восстановления и синхронной записи:
+// их включают Restore и SetSyncWrite.
+func Open(ctx context.Context, dsn string) (Storage, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("storage: parse dsn: %w", err)
+	}
+
+	switch u.Scheme {
+	case "mem", "memory":
+		return NewMemStorage(), nil
+	case "file":
+		path := filePath(u)
+		if path == "" {
+			return nil, fmt.Errorf("storage: empty file path in %q", dsn)
+		}
+		return NewFileStorage(path, false), nil
+	case "bolt":
+		path := filePath(u)
+		if path == "" {
+			return nil, fmt.Errorf("storage: empty bolt path in %q", dsn)
+		}
+		return NewBoltStorage(path)
+	case "postgres", "postgresql":
+		return NewPostgresStorage(ctx, dsn)
+	case "":
+		if strings.Contains(dsn, "=") {
+			return NewPostgresStorage(ctx, dsn)
+		}
+		return nil, fmt.Errorf("storage: dsn %q has no scheme", dsn)
+	default:
+		return nil, fmt.Errorf("storage: unknown scheme %q", u.Scheme)
+	}
+}
+
+// filePath извлекает путь из file-URL. Хост не поддерживается
+// и считается началом относительного пути: file://data/m.json
+// равносилен file:data/m.json.
+func filePath(u *url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Host + u.Path
+}
@@ -1,34 +1,133 @@
 package storage
 
 import (
-	"encoding/json"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/LemuriiL/MetricsAllerts/internal/model"
 )
 
+const (
+	// defaultCompactSize — размер журнала, после которого он сворачивается
+	// в снимок.
+	defaultCompactSize = 4 << 20
+	// DefaultSnapshotBackups — сколько прежних снимков хранится по умолчанию.
+	DefaultSnapshotBackups = 3
+)
+
+// FileStorage хранит метрики в памяти и сохраняет их в файл-снимок path
+// и журнал изменений path+".wal". Каждое изменение дописывается в журнал;
+// когда журнал вырастает до compactSize, состояние записывается новым
+// снимком, а журнал очищается. Restore читает снимок и применяет журнал.
+// Перед заменой снимка прежний сохраняется в path.bak.1, более старые
+// сдвигаются дальше; хранится не больше backups копий.
+//
+// При syncWrite каждое изменение дожидается fsync журнала; одновременные
+// записи объединяются в один fsync. Без syncWrite журнал сбрасывается
+// на диск вызовом Sync.
 type FileStorage struct {
-	base      *MemStorage
-	path      string
-	syncWrite bool
-	mu        sync.Mutex
+	base        *MemStorage
+	syncWrite   atomic.Bool
+	compactSize int64
+
+	// mu защищает путь, журнал и порядок записей в нём.
+	mu       sync.Mutex
+	path     string
+	wal      *os.File
+	buf      *bufio.Writer
+	walSize  int64
+	written  uint64
+	backups  int
+	recovery bool
+
+	// syncMu выстраивает fsync журнала в очередь; synced — номер
+	// последней записи, которая точно на диске. Порядок захвата:
+	// syncMu, затем mu.
+	syncMu sync.Mutex
+	synced uint64
 }
 
 func NewFileStorage(path string, syncWrite bool) *FileStorage {
-	return &FileStorage{
-		base:      NewMemStorage(),
-		path:      path,
-		syncWrite: syncWrite,
+	s := &FileStorage{
+		base:        NewMemStorage(),
+		path:        path,
+		compactSize: defaultCompactSize,
+		backups:     DefaultSnapshotBackups,
 	}
+	s.syncWrite.Store(syncWrite)
+	return s
 }
 
-func (s *FileStorage) SetGauge(name string, value float64) {
-	s.base.SetGauge(name, value)
-	if s.syncWrite {
-		_ = s.Save()
+// SetSyncWrite включает или выключает fsync журнала после каждой записи.
+func (s *FileStorage) SetSyncWrite(syncWrite bool) {
+	s.syncWrite.Store(syncWrite)
+}
+
+// SetBackups задаёт, сколько прежних снимков хранить; 0 отключает копии.
+// Лишние копии удаляются при следующей записи снимка.
+func (s *FileStorage) SetBackups(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backups = n
+}
+
+// SetRecover включает режим восстановления: если снимок повреждён,
+// Restore берёт самую свежую целую резервную копию вместо ошибки.
+// По умолчанию выключен.
+func (s *FileStorage) SetRecover(recovery bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recovery = recovery
+}
+
+// SetPath переключает хранилище на новый файл и сразу сохраняет в него
+// текущие метрики. Если записать файл не удалось, остаётся прежний путь.
+// Журнал прежнего файла удаляется: его записи уже есть в новом снимке.
+// Чужой журнал рядом с новым файлом тоже удаляется, иначе Restore
+// применил бы его поверх снимка.
+func (s *FileStorage) SetPath(path string) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.writeSnapshot(path); err != nil {
+		return err
+	}
+	if s.wal != nil {
+		s.wal.Close()
+		s.wal, s.buf = nil, nil
+		if err := os.Remove(walPath(s.path)); err != nil && !os.IsNotExist(err) {
+			log.Printf("storage: failed to remove %s: %v", walPath(s.path), err)
+		}
+	}
+	if err := os.Remove(walPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
 	}
+	s.path = path
+	return nil
+}
+
+// Path возвращает путь к файлу хранилища.
+func (s *FileStorage) Path() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.path
+}
+
+func (s *FileStorage) SetGauge(name string, value float64) {
+	_ = s.update(func() error {
+		s.base.SetGauge(name, value)
+		return nil
+	}, metricRef{models.Gauge, name})
 }
 
 func (s *FileStorage) GetGauge(name string) (float64, bool) {
@@ -36,10 +135,10 @@ func (s *FileStorage) GetGauge(name string) (float64, bool) {
 }
 
 func (s *FileStorage) SetCounter(name string, value int64) {
-	s.base.SetCounter(name, value)
-	if s.syncWrite {
-		_ = s.Save()
-	}
+	_ = s.update(func() error {
+		s.base.SetCounter(name, value)
+		return nil
+	}, metricRef{models.Counter, name})
 }
 
 func (s *FileStorage) GetCounter(name string) (int64, bool) {
@@ -54,85 +153,437 @@ func (s *FileStorage) GetAllCounters() map[string]int64 {
 	return s.base.GetAllCounters()
 }
 
+func (s *FileStorage) SetHistogram(name string, value models.HistogramValue) {
+	_ = s.update(func() error {
+		s.base.SetHistogram(name, value)
+		return nil
+	}, metricRef{models.Histogram, name})
+}
+
+func (s *FileStorage) GetHistogram(name string) (models.HistogramValue, bool) {
+	return s.base.GetHistogram(name)
+}
+
+func (s *FileStorage) GetAllHistograms() map[string]models.HistogramValue {
+	return s.base.GetAllHistograms()
+}
+
+func (s *FileStorage) UpdateBatch(metrics []models.Metrics) error {
+	refs := make([]metricRef, 0, len(metrics))
+	seen := make(map[metricRef]bool, len(metrics))
+	for _, m := range metrics {
+		ref := metricRef{m.MType, m.Key()}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	return s.update(func() error {
+		return s.base.UpdateBatch(metrics)
+	}, refs...)
+}
+
+func (s *FileStorage) Delete(mtype, name string) (bool, error) {
+	var ok bool
+	err := s.update(func() error {
+		if ok, _ = s.base.Delete(mtype, name); !ok {
+			return errNotChanged
+		}
+		return nil
+	}, metricRef{mtype, name})
+	return ok, err
+}
+
+func (s *FileStorage) ResetCounter(name string) (bool, error) {
+	var ok bool
+	err := s.update(func() error {
+		if ok, _ = s.base.ResetCounter(name); !ok {
+			return errNotChanged
+		}
+		return nil
+	}, metricRef{models.Counter, name})
+	return ok, err
+}
+
+// Ping проверяет, что в каталог файла можно писать: создаёт и удаляет
+// в нём временный файл.
+func (s *FileStorage) Ping(ctx context.Context) error {
+	dir := filepath.Dir(s.Path())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".ping-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// errNotChanged сообщает update, что изменение не состоялось
+// и записывать в журнал нечего.
+var errNotChanged = errors.New("not changed")
+
+// metricRef указывает на метрику, состояние которой попадает в журнал.
+type metricRef struct {
+	mtype string
+	key   string
+}
+
+// update применяет изменение и дописывает в журнал итоговое состояние
+// затронутых метрик. Изменение и запись идут под одной блокировкой,
+// чтобы порядок записей в журнале совпадал с порядком изменений.
+func (s *FileStorage) update(apply func() error, refs ...metricRef) error {
+	s.mu.Lock()
+	if err := apply(); err != nil {
+		s.mu.Unlock()
+		if errors.Is(err, errNotChanged) {
+			return nil
+		}
+		return err
+	}
+	entries := make([]walEntry, 0, len(refs))
+	for _, ref := range refs {
+		entries = append(entries, s.entry(ref))
+	}
+	seq, err := s.appendLocked(entries)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if s.syncWrite.Load() {
+		return s.syncTo(seq)
+	}
+	return nil
+}
+
+// entry возвращает текущее состояние метрики для журнала.
+func (s *FileStorage) entry(ref metricRef) walEntry {
+	id, labels := models.ParseKey(ref.key)
+	e := walEntry{Metrics: models.Metrics{ID: id, MType: ref.mtype, Labels: labels}}
+	var ok bool
+	switch ref.mtype {
+	case models.Gauge:
+		var v float64
+		v, ok = s.base.GetGauge(ref.key)
+		e.Value = &v
+	case models.Counter:
+		var d int64
+		d, ok = s.base.GetCounter(ref.key)
+		e.Delta = &d
+	case models.Histogram:
+		var h models.HistogramValue
+		h, ok = s.base.GetHistogram(ref.key)
+		e.Histogram = &h
+	}
+	if !ok {
+		e.Value, e.Delta, e.Histogram = nil, nil, nil
+		e.Deleted = true
+	}
+	return e
+}
+
+// appendLocked дописывает запись в журнал и возвращает её номер.
+// Первая запись после запуска начинает журнал заново от свежего снимка,
+// чтобы без Restore прежние данные не смешались с новыми.
+func (s *FileStorage) appendLocked(entries []walEntry) (uint64, error) {
+	if s.wal == nil {
+		if err := s.compactLocked(); err != nil {
+			return 0, err
+		}
+		return s.written, nil
+	}
+
+	rec, err := encodeWALRecord(entries)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.buf.Write(rec); err != nil {
+		return 0, err
+	}
+	s.written++
+	s.walSize += int64(len(rec))
+
+	if s.walSize >= s.compactSize {
+		if err := s.compactLocked(); err != nil {
+			return 0, err
+		}
+	}
+	return s.written, nil
+}
+
+// syncTo дожидается, пока запись seq окажется на диске. Пока один вызов
+// выполняет fsync, остальные ждут его и, если их записи он уже покрыл,
+// возвращаются без своего fsync.
+func (s *FileStorage) syncTo(seq uint64) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	if s.synced >= seq {
+		return nil
+	}
+
+	s.mu.Lock()
+	if s.wal == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	err := s.buf.Flush()
+	target, f := s.written, s.wal
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	s.synced = target
+	return nil
+}
+
+// Sync сбрасывает журнал на диск.
+func (s *FileStorage) Sync() error {
+	s.mu.Lock()
+	seq := s.written
+	s.mu.Unlock()
+	return s.syncTo(seq)
+}
+
+// Save записывает все метрики в снимок и очищает журнал.
 func (s *FileStorage) Save() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.compactLocked()
+}
 
+// Close сбрасывает журнал на диск и закрывает его.
+func (s *FileStorage) Close() error {
+	if err := s.Sync(); err != nil {
+		return err
+	}
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal == nil {
+		return nil
+	}
+	err := s.wal.Close()
+	s.wal, s.buf = nil, nil
+	return err
+}
+
+// compactLocked записывает снимок и очищает журнал. Если процесс упадёт
+// между этими шагами, при восстановлении старый журнал применится поверх
+// нового снимка и даст то же состояние.
+func (s *FileStorage) compactLocked() error {
+	if err := s.writeSnapshot(s.path); err != nil {
+		return err
+	}
+
+	if s.wal == nil {
+		f, err := os.OpenFile(walPath(s.path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		s.wal, s.buf = f, bufio.NewWriter(f)
+	} else {
+		s.buf.Reset(s.wal)
+		if err := s.wal.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := s.wal.Seek(0, 0); err != nil {
+			return err
+		}
+	}
+	s.walSize = 0
+	return nil
+}
+
+func (s *FileStorage) snapshot() []models.Metrics {
 	gauges := s.base.GetAllGauges()
 	counters := s.base.GetAllCounters()
+	histograms := s.base.GetAllHistograms()
 
-	res := make([]models.Metrics, 0, len(gauges)+len(counters))
+	res := make([]models.Metrics, 0, len(gauges)+len(counters)+len(histograms))
 
-	for name, v := range gauges {
+	for key, v := range gauges {
 		val := v
+		id, labels := models.ParseKey(key)
 		res = append(res, models.Metrics{
-			ID:    name,
-			MType: models.Gauge,
-			Value: &val,
+			ID:     id,
+			MType:  models.Gauge,
+			Value:  &val,
+			Labels: labels,
 		})
 	}
 
-	for name, v := range counters {
+	for key, v := range counters {
 		d := v
+		id, labels := models.ParseKey(key)
+		res = append(res, models.Metrics{
+			ID:     id,
+			MType:  models.Counter,
+			Delta:  &d,
+			Labels: labels,
+		})
+	}
+
+	for key, v := range histograms {
+		h := v
+		id, labels := models.ParseKey(key)
 		res = append(res, models.Metrics{
-			ID:    name,
-			MType: models.Counter,
-			Delta: &d,
+			ID:        id,
+			MType:     models.Histogram,
+			Histogram: &h,
+			Labels:    labels,
 		})
 	}
+	return res
+}
 
-	data, err := json.Marshal(res)
+// writeSnapshot атомарно заменяет снимок в path: пишет временный файл,
+// сбрасывает его на диск и переименовывает.
+func (s *FileStorage) writeSnapshot(path string) error {
+	data, err := encodeSnapshot(s.snapshot(), time.Now())
 	if err != nil {
 		return err
 	}
 
-	dir := filepath.Dir(s.path)
+	dir := filepath.Dir(path)
 	if dir != "." && dir != "" {
 		if err := os.MkdirAll(dir, 0o755); err != nil {
 			return err
 		}
 	}
 
-	tmp := s.path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := rotateBackups(path, s.backups); err != nil {
+		return err
+	}
+	if err := removeBackups(path, s.backups); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir сбрасывает на диск каталог, чтобы переименование пережило сбой.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
 		return err
 	}
+	defer d.Close()
+	return d.Sync()
+}
 
-	return os.Rename(tmp, s.path)
+func walPath(path string) string {
+	return path + ".wal"
 }
 
+// Restore читает снимок и применяет к нему журнал. Оборванная последняя
+// запись журнала — обычное следствие сбоя посреди записи: она и всё
+// после неё отбрасываются.
 func (s *FileStorage) Restore() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	data, err := os.ReadFile(s.path)
+	if err := s.restoreSnapshot(); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(walPath(s.path))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
 		}
 		return err
 	}
+	off, err := replayWAL(s.base, data)
+	if err != nil {
+		log.Printf("storage: %s: dropping %d bytes after offset %d: %v", walPath(s.path), len(data)-off, off, err)
+	}
+	return nil
+}
 
-	var items []models.Metrics
-	if err := json.Unmarshal(data, &items); err != nil {
-		return err
+// restoreSnapshot загружает снимок. Прежний формат без конверта
+// читается как есть и переписывается в новый при первой записи.
+// В режиме recover повреждённый снимок заменяется самой свежей целой
+// резервной копией. Снимок неизвестной версии всегда даёт ошибку.
+func (s *FileStorage) restoreSnapshot() error {
+	items, err := readSnapshot(s.path)
+	if errors.Is(err, errBadSnapshot) && s.recovery {
+		log.Printf("storage: %s: %v; trying backups", s.path, err)
+		for i := 1; i <= s.backups; i++ {
+			bak := backupPath(s.path, i)
+			if items, err = readSnapshot(bak); err == nil {
+				log.Printf("storage: restored from %s", bak)
+				break
+			}
+			if !os.IsNotExist(err) {
+				log.Printf("storage: %s: %v", bak, err)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("storage: no usable snapshot for %s", s.path)
+		}
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("storage: %s: %w", s.path, err)
 	}
 
-	prev := s.syncWrite
-	s.syncWrite = false
 	for _, m := range items {
 		switch m.MType {
 		case models.Gauge:
 			if m.Value != nil {
-				s.base.SetGauge(m.ID, *m.Value)
+				s.base.SetGauge(m.Key(), *m.Value)
 			}
 		case models.Counter:
 			if m.Delta != nil {
-				s.base.SetCounter(m.ID, *m.Delta)
+				s.base.SetCounter(m.Key(), *m.Delta)
+			}
+		case models.Histogram:
+			if m.Histogram != nil && m.Histogram.Validate() == nil {
+				s.base.SetHistogram(m.Key(), *m.Histogram)
 			}
 		}
 	}
-	s.syncWrite = prev
-
 	return nil
 }
+
+func readSnapshot(path string) ([]models.Metrics, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	items, version, err := decodeSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+	if version < snapshotVersion {
+		log.Printf("storage: %s: snapshot format version %d will be migrated to %d on next save", path, version, snapshotVersion)
+	}
+	return items, nil
+}
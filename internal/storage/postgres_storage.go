@@ -0,0 +1,366 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/migrations"
+)
+
+const queryTimeout = 5 * time.Second
+
+type PostgresStorage struct {
+	db *sql.DB
+}
+
+func NewPostgresStorage(ctx context.Context, dsn string) (*PostgresStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migrate(ctx, db, migrations.FS); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresStorage{db: db}, nil
+}
+
+func (s *PostgresStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *PostgresStorage) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+func (s *PostgresStorage) SetGauge(name string, value float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	if err := upsertGauge(ctx, s.db, name, value); err != nil {
+		log.Printf("postgres: set gauge %s: %v", name, err)
+	}
+}
+
+func (s *PostgresStorage) GetGauge(name string) (float64, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	var val float64
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM gauges WHERE id = $1`, name).Scan(&val)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("postgres: get gauge %s: %v", name, err)
+		}
+		return 0, false
+	}
+	return val, true
+}
+
+func (s *PostgresStorage) SetCounter(name string, value int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	if err := upsertCounter(ctx, s.db, name, value); err != nil {
+		log.Printf("postgres: set counter %s: %v", name, err)
+	}
+}
+
+func (s *PostgresStorage) GetCounter(name string) (int64, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	var val int64
+	err := s.db.QueryRowContext(ctx, `SELECT delta FROM counters WHERE id = $1`, name).Scan(&val)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("postgres: get counter %s: %v", name, err)
+		}
+		return 0, false
+	}
+	return val, true
+}
+
+func (s *PostgresStorage) GetAllGauges() map[string]float64 {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	res := make(map[string]float64)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, value FROM gauges`)
+	if err != nil {
+		log.Printf("postgres: get all gauges: %v", err)
+		return res
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id  string
+			val float64
+		)
+		if err := rows.Scan(&id, &val); err != nil {
+			log.Printf("postgres: scan gauge: %v", err)
+			continue
+		}
+		res[id] = val
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("postgres: get all gauges: %v", err)
+	}
+	return res
+}
+
+func (s *PostgresStorage) GetAllCounters() map[string]int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	res := make(map[string]int64)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, delta FROM counters`)
+	if err != nil {
+		log.Printf("postgres: get all counters: %v", err)
+		return res
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id  string
+			val int64
+		)
+		if err := rows.Scan(&id, &val); err != nil {
+			log.Printf("postgres: scan counter: %v", err)
+			continue
+		}
+		res[id] = val
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("postgres: get all counters: %v", err)
+	}
+	return res
+}
+
+func (s *PostgresStorage) SetHistogram(name string, value models.HistogramValue) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	err := func() error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := upsertHistogram(ctx, tx, name, value); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		log.Printf("postgres: set histogram %s: %v", name, err)
+	}
+}
+
+func (s *PostgresStorage) GetHistogram(name string) (models.HistogramValue, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM histograms WHERE id = $1`, name).Scan(&data)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("postgres: get histogram %s: %v", name, err)
+		}
+		return models.HistogramValue{}, false
+	}
+
+	var val models.HistogramValue
+	if err := json.Unmarshal(data, &val); err != nil {
+		log.Printf("postgres: decode histogram %s: %v", name, err)
+		return models.HistogramValue{}, false
+	}
+	return val, true
+}
+
+func (s *PostgresStorage) GetAllHistograms() map[string]models.HistogramValue {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	res := make(map[string]models.HistogramValue)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, data FROM histograms`)
+	if err != nil {
+		log.Printf("postgres: get all histograms: %v", err)
+		return res
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id   string
+			data []byte
+			val  models.HistogramValue
+		)
+		if err := rows.Scan(&id, &data); err != nil {
+			log.Printf("postgres: scan histogram: %v", err)
+			continue
+		}
+		if err := json.Unmarshal(data, &val); err != nil {
+			log.Printf("postgres: decode histogram %s: %v", id, err)
+			continue
+		}
+		res[id] = val
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("postgres: get all histograms: %v", err)
+	}
+	return res
+}
+
+func (s *PostgresStorage) UpdateBatch(metrics []models.Metrics) error {
+	if err := ValidateBatch(metrics); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range metrics {
+		switch m.MType {
+		case models.Gauge:
+			err = upsertGauge(ctx, tx, m.Key(), *m.Value)
+		case models.Counter:
+			err = upsertCounter(ctx, tx, m.Key(), *m.Delta)
+		case models.Histogram:
+			err = upsertHistogram(ctx, tx, m.Key(), *m.Histogram)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) Delete(mtype, name string) (bool, error) {
+	var table string
+	switch mtype {
+	case models.Gauge:
+		table = "gauges"
+	case models.Counter:
+		table = "counters"
+	case models.Histogram:
+		table = "histograms"
+	default:
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE id = $1`, name)
+	if err != nil {
+		return false, fmt.Errorf("postgres: delete %s %s: %w", mtype, name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("postgres: delete %s %s: %w", mtype, name, err)
+	}
+	return n > 0, nil
+}
+
+func (s *PostgresStorage) ResetCounter(name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `UPDATE counters SET delta = 0 WHERE id = $1`, name)
+	if err != nil {
+		return false, fmt.Errorf("postgres: reset counter %s: %w", name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("postgres: reset counter %s: %w", name, err)
+	}
+	return n > 0, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func upsertGauge(ctx context.Context, db execer, name string, value float64) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO gauges (id, value) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET value = EXCLUDED.value`,
+		name, value)
+	return err
+}
+
+func upsertCounter(ctx context.Context, db execer, name string, delta int64) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO counters (id, delta) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET delta = counters.delta + EXCLUDED.delta`,
+		name, delta)
+	return err
+}
+
+// upsertHistogram прибавляет delta к гистограмме внутри транзакции tx:
+// новая запись вставляется как есть, существующая блокируется
+// и обновляется по правилам mergeHistogram.
+func upsertHistogram(ctx context.Context, tx *sql.Tx, name string, delta models.HistogramValue) error {
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO histograms (id, data) VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING`,
+		name, data)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return err
+	}
+
+	var (
+		raw []byte
+		old models.HistogramValue
+	)
+	err = tx.QueryRowContext(ctx, `SELECT data FROM histograms WHERE id = $1 FOR UPDATE`, name).Scan(&raw)
+	if err != nil {
+		return err
+	}
+	exists := json.Unmarshal(raw, &old) == nil && old.Validate() == nil
+
+	data, err = json.Marshal(mergeHistogram(old, exists, delta))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE histograms SET data = $2 WHERE id = $1`, name, data)
+	return err
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+// snapshotVersion — версия формата снимка FileStorage. Версия 0 —
+// прежний формат: голый JSON-массив метрик без заголовка.
+const snapshotVersion = 1
+
+var errBadSnapshot = errors.New("bad snapshot")
+
+// errUnsupportedSnapshot — снимок записан другой, обычно более новой,
+// версией сервера. Это не повреждение: откат на резервную копию
+// потерял бы данные, а следующая запись затёрла бы новый снимок.
+var errUnsupportedSnapshot = errors.New("unsupported snapshot version")
+
+// snapshotFile — конверт снимка. Checksum — SHA-256 в hex от Metrics
+// в компактной записи JSON.
+type snapshotFile struct {
+	Version   int             `json:"version"`
+	WrittenAt time.Time       `json:"written_at"`
+	Host      string          `json:"host,omitempty"`
+	Checksum  string          `json:"checksum"`
+	Metrics   json.RawMessage `json:"metrics"`
+}
+
+func encodeSnapshot(metrics []models.Metrics, now time.Time) ([]byte, error) {
+	body, err := json.Marshal(metrics)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	sum := sha256.Sum256(body)
+	return json.Marshal(snapshotFile{
+		Version:   snapshotVersion,
+		WrittenAt: now.UTC(),
+		Host:      host,
+		Checksum:  hex.EncodeToString(sum[:]),
+		Metrics:   body,
+	})
+}
+
+// decodeSnapshot разбирает снимок любой известной версии и сообщает
+// его версию. Повреждённый файл или неверная контрольная сумма дают
+// ошибку errBadSnapshot, версия новее известной — errUnsupportedSnapshot.
+func decodeSnapshot(data []byte) ([]models.Metrics, int, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var items []models.Metrics
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", errBadSnapshot, err)
+		}
+		return items, 0, nil
+	}
+
+	var f snapshotFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errBadSnapshot, err)
+	}
+	switch {
+	case f.Version <= 0:
+		// Версия 0 бывает только у голого массива.
+		return nil, f.Version, fmt.Errorf("%w: bad version %d", errBadSnapshot, f.Version)
+	case f.Version > snapshotVersion:
+		return nil, f.Version, fmt.Errorf("%w %d", errUnsupportedSnapshot, f.Version)
+	}
+
+	var body bytes.Buffer
+	if err := json.Compact(&body, f.Metrics); err != nil {
+		return nil, f.Version, fmt.Errorf("%w: %v", errBadSnapshot, err)
+	}
+	sum := sha256.Sum256(body.Bytes())
+	if hex.EncodeToString(sum[:]) != f.Checksum {
+		return nil, f.Version, fmt.Errorf("%w: checksum mismatch", errBadSnapshot)
+	}
+
+	var items []models.Metrics
+	if err := json.Unmarshal(body.Bytes(), &items); err != nil {
+		return nil, f.Version, fmt.Errorf("%w: %v", errBadSnapshot, err)
+	}
+	return items, f.Version, nil
+}
+
+// backupPath возвращает путь n-й резервной копии снимка, от новой к старой.
+func backupPath(path string, n int) string {
+	return path + ".bak." + strconv.Itoa(n)
+}
+
+// rotateBackups сдвигает копии path.bak.1..n на одну позицию и делает
+// path.bak.1 из текущего снимка. Снимок остаётся на месте, чтобы сбой
+// посреди ротации не оставил хранилище без файла.
+func rotateBackups(path string, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	if err := os.Remove(backupPath(path, n)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := n - 1; i >= 1; i-- {
+		if err := os.Rename(backupPath(path, i), backupPath(path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err := os.Link(path, backupPath(path, 1))
+	switch {
+	case err == nil, os.IsNotExist(err):
+		return nil
+	default:
+		// Файловая система без жёстких ссылок.
+		return copyFile(path, backupPath(path, 1))
+	}
+}
+
+// removeBackups удаляет копии сверх n, оставшиеся после уменьшения
+// их числа.
+func removeBackups(path string, n int) error {
+	for i := n + 1; ; i++ {
+		err := os.Remove(backupPath(path, i))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotEnvelope(t *testing.T) {
+	v := 1.5
+	metrics := []models.Metrics{{ID: "Alloc", MType: models.Gauge, Value: &v}}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	data, err := encodeSnapshot(metrics, now)
+	require.NoError(t, err)
+
+	var f snapshotFile
+	require.NoError(t, json.Unmarshal(data, &f))
+	assert.Equal(t, snapshotVersion, f.Version)
+	assert.Equal(t, now, f.WrittenAt)
+	assert.Len(t, f.Checksum, 64)
+
+	got, version, err := decodeSnapshot(data)
+	require.NoError(t, err)
+	assert.Equal(t, snapshotVersion, version)
+	assert.Equal(t, metrics, got)
+
+	tests := map[string][]byte{
+		"truncated":        data[:len(data)/2],
+		"checksum":         []byte(`{"version":1,"checksum":"00","metrics":[]}`),
+		"garbage":          []byte("\x00\x01"),
+		"missing version":  []byte(`{"metrics":[]}`),
+		"negative version": []byte(`{"version":-1,"metrics":[]}`),
+		"truncated legacy": []byte(`[{"id":"Alloc","type":"gauge"`),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := decodeSnapshot(data)
+			assert.ErrorIs(t, err, errBadSnapshot)
+		})
+	}
+
+	_, version, err = decodeSnapshot([]byte(`{"version":99,"metrics":[]}`))
+	assert.Equal(t, 99, version)
+	assert.ErrorIs(t, err, errUnsupportedSnapshot)
+	assert.NotErrorIs(t, err, errBadSnapshot)
+}
+
+func TestFileStorageMigratesLegacySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	legacy := []byte(`[{"id":"Alloc","type":"gauge","value":2},{"id":"PollCount","type":"counter","delta":3}]`)
+	require.NoError(t, os.WriteFile(path, legacy, 0o644))
+
+	s := NewFileStorage(path, true)
+	require.NoError(t, s.Restore())
+	assert.Equal(t, map[string]float64{"Alloc": 2}, s.GetAllGauges())
+
+	s.SetCounter("PollCount", 1)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	_, version, err := decodeSnapshot(data)
+	require.NoError(t, err)
+	assert.Equal(t, snapshotVersion, version, "first write rewrites the snapshot in the new format")
+
+	bak, err := os.ReadFile(backupPath(path, 1))
+	require.NoError(t, err)
+	assert.Equal(t, legacy, bak, "legacy file is kept as a backup")
+	assert.Equal(t, map[string]int64{"PollCount": 4}, restored(t, path).counters)
+}
+
+func TestFileStorageSnapshotBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewFileStorage(path, false)
+	s.SetBackups(2)
+
+	for i := 1; i <= 4; i++ {
+		s.SetGauge("Alloc", float64(i))
+		require.NoError(t, s.Save())
+	}
+
+	for n, want := range map[int]float64{1: 3, 2: 2} {
+		items, err := readSnapshot(backupPath(path, n))
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		assert.Equal(t, want, *items[0].Value, "backup %d", n)
+	}
+	_, err := os.Stat(backupPath(path, 3))
+	assert.True(t, os.IsNotExist(err))
+
+	s.SetBackups(0)
+	require.NoError(t, s.Save())
+	for n := 1; n <= 2; n++ {
+		_, err := os.Stat(backupPath(path, n))
+		assert.True(t, os.IsNotExist(err), "backup %d is removed", n)
+	}
+}
+
+func TestFileStorageRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewFileStorage(path, false)
+	s.SetBackups(2)
+	s.SetGauge("Alloc", 1)
+	require.NoError(t, s.Save())
+	s.SetGauge("Alloc", 2)
+	require.NoError(t, s.Save())
+	s.SetGauge("Alloc", 3)
+	require.NoError(t, s.Save())
+	require.NoError(t, s.Close())
+
+	corrupt := func(path string) {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, data[:len(data)-10], 0o644))
+	}
+	corrupt(path)
+
+	strict := NewFileStorage(path, false)
+	assert.ErrorIs(t, strict.Restore(), errBadSnapshot)
+
+	recovered := NewFileStorage(path, false)
+	recovered.SetRecover(true)
+	require.NoError(t, recovered.Restore())
+	assert.Equal(t, map[string]float64{"Alloc": 2}, recovered.GetAllGauges())
+
+	corrupt(backupPath(path, 1))
+	recovered = NewFileStorage(path, false)
+	recovered.SetRecover(true)
+	require.NoError(t, recovered.Restore())
+	assert.Equal(t, map[string]float64{"Alloc": 1}, recovered.GetAllGauges())
+
+	corrupt(backupPath(path, 2))
+	recovered = NewFileStorage(path, false)
+	recovered.SetRecover(true)
+	assert.Error(t, recovered.Restore(), "no intact snapshot left")
+}
+
+func TestFileStorageRecoverSkipsNewerSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewFileStorage(path, false)
+	s.SetBackups(2)
+	s.SetGauge("Alloc", 1)
+	require.NoError(t, s.Save())
+	s.SetGauge("Alloc", 2)
+	require.NoError(t, s.Save())
+	require.NoError(t, s.Close())
+
+	newer := []byte(`{"version":99,"metrics":[{"id":"Alloc","type":"gauge","value":3}]}`)
+	require.NoError(t, os.WriteFile(path, newer, 0o644))
+
+	recovered := NewFileStorage(path, false)
+	recovered.SetRecover(true)
+	assert.ErrorIs(t, recovered.Restore(), errUnsupportedSnapshot)
+	assert.Empty(t, recovered.GetAllGauges(), "backup is not restored")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, newer, data, "newer snapshot is left untouched")
+}
@@ -1,6 +1,15 @@
 package storage
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+var ErrInvalidMetric = errors.New("invalid metric")
 
 type Storage interface {
 	SetGauge(name string, value float64)
@@ -9,18 +18,86 @@ type Storage interface {
 	GetCounter(name string) (int64, bool)
 	GetAllGauges() map[string]float64
 	GetAllCounters() map[string]int64
+	SetHistogram(name string, value models.HistogramValue)
+	GetHistogram(name string) (models.HistogramValue, bool)
+	GetAllHistograms() map[string]models.HistogramValue
+	UpdateBatch(metrics []models.Metrics) error
+	// Delete удаляет метрику типа mtype и сообщает, была ли она.
+	// Ошибка означает сбой хранилища, а не отсутствие метрики.
+	Delete(mtype, name string) (bool, error)
+	// ResetCounter обнуляет счётчик и сообщает, был ли он.
+	ResetCounter(name string) (bool, error)
+	// Ping проверяет, что хранилище доступно для записи.
+	Ping(ctx context.Context) error
+}
+
+func ValidateMetric(m models.Metrics) error {
+	if m.ID == "" {
+		return fmt.Errorf("%w: empty id", ErrInvalidMetric)
+	}
+	if !models.ValidID(m.ID) {
+		return fmt.Errorf("%w: bad id %q", ErrInvalidMetric, m.ID)
+	}
+	for name := range m.Labels {
+		if !models.ValidLabelName(name) {
+			return fmt.Errorf("%w: bad label name %q", ErrInvalidMetric, name)
+		}
+	}
+	switch m.MType {
+	case models.Gauge:
+		if m.Value == nil {
+			return fmt.Errorf("%w: gauge %s without value", ErrInvalidMetric, m.ID)
+		}
+	case models.Counter:
+		if m.Delta == nil {
+			return fmt.Errorf("%w: counter %s without delta", ErrInvalidMetric, m.ID)
+		}
+	case models.Histogram:
+		if m.Histogram == nil {
+			return fmt.Errorf("%w: histogram %s without buckets", ErrInvalidMetric, m.ID)
+		}
+		if err := m.Histogram.Validate(); err != nil {
+			return fmt.Errorf("%w: histogram %s: %v", ErrInvalidMetric, m.ID, err)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidMetric, m.MType)
+	}
+	return nil
+}
+
+func ValidateBatch(metrics []models.Metrics) error {
+	for _, m := range metrics {
+		if err := ValidateMetric(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeHistogram прибавляет delta к накопленной гистограмме. Если границы
+// корзин изменились, накопленные данные заменяются на delta.
+func mergeHistogram(old models.HistogramValue, exists bool, delta models.HistogramValue) models.HistogramValue {
+	if exists {
+		res := old.Clone()
+		if res.Merge(delta) == nil {
+			return res
+		}
+	}
+	return delta.Clone()
 }
 
 type MemStorage struct {
-	mu       sync.RWMutex
-	gauges   map[string]float64
-	counters map[string]int64
+	mu         sync.RWMutex
+	gauges     map[string]float64
+	counters   map[string]int64
+	histograms map[string]models.HistogramValue
 }
 
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
-		gauges:   make(map[string]float64),
-		counters: make(map[string]int64),
+		gauges:     make(map[string]float64),
+		counters:   make(map[string]int64),
+		histograms: make(map[string]models.HistogramValue),
 	}
 }
 
@@ -76,3 +153,86 @@ func (s *MemStorage) GetAllCounters() map[string]int64 {
 	}
 	return res
 }
+
+func (s *MemStorage) SetHistogram(name string, value models.HistogramValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exists := s.histograms[name]
+	s.histograms[name] = mergeHistogram(old, exists, value)
+}
+
+func (s *MemStorage) GetHistogram(name string) (models.HistogramValue, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	val, ok := s.histograms[name]
+	return val.Clone(), ok
+}
+
+func (s *MemStorage) GetAllHistograms() map[string]models.HistogramValue {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make(map[string]models.HistogramValue, len(s.histograms))
+	for k, v := range s.histograms {
+		res[k] = v.Clone()
+	}
+	return res
+}
+
+func (s *MemStorage) UpdateBatch(metrics []models.Metrics) error {
+	if err := ValidateBatch(metrics); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range metrics {
+		switch m.MType {
+		case models.Gauge:
+			s.gauges[m.Key()] = *m.Value
+		case models.Counter:
+			s.counters[m.Key()] += *m.Delta
+		case models.Histogram:
+			old, exists := s.histograms[m.Key()]
+			s.histograms[m.Key()] = mergeHistogram(old, exists, *m.Histogram)
+		}
+	}
+	return nil
+}
+
+func (s *MemStorage) Delete(mtype, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ok bool
+	switch mtype {
+	case models.Gauge:
+		_, ok = s.gauges[name]
+		delete(s.gauges, name)
+	case models.Counter:
+		_, ok = s.counters[name]
+		delete(s.counters, name)
+	case models.Histogram:
+		_, ok = s.histograms[name]
+		delete(s.histograms, name)
+	}
+	return ok, nil
+}
+
+func (s *MemStorage) ResetCounter(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.counters[name]; !ok {
+		return false, nil
+	}
+	s.counters[name] = 0
+	return true, nil
+}
+
+func (s *MemStorage) Ping(ctx context.Context) error {
+	return nil
+}
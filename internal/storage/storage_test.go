@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorageRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	a := models.Key("Alloc", map[string]string{"host": "a"})
+	b := models.Key("Alloc", map[string]string{"host": "b", "instance": "x"})
+
+	s := NewFileStorage(path, false)
+	s.SetGauge(a, 1)
+	s.SetGauge(b, 2)
+	s.SetGauge("Alloc", 3)
+	s.SetCounter(a, 4)
+	h := models.NewHistogram([]float64{1})
+	h.Observe(2)
+	s.SetHistogram(a, *h)
+	require.NoError(t, s.Save())
+
+	restored := NewFileStorage(path, false)
+	require.NoError(t, restored.Restore())
+	assert.Equal(t, map[string]float64{a: 1, b: 2, "Alloc": 3}, restored.GetAllGauges())
+	assert.Equal(t, map[string]int64{a: 4}, restored.GetAllCounters())
+	assert.Equal(t, map[string]models.HistogramValue{a: *h}, restored.GetAllHistograms())
+}
+
+func TestFileStoragePing(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileStorage(filepath.Join(dir, "sub", "metrics.json"), false)
+	require.NoError(t, s.Ping(context.Background()))
+	entries, err := os.ReadDir(filepath.Join(dir, "sub"))
+	require.NoError(t, err)
+	assert.Empty(t, entries, "ping leaves no files behind")
+
+	blocker := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(blocker, nil, 0o644))
+	s = NewFileStorage(filepath.Join(blocker, "metrics.json"), false)
+	assert.Error(t, s.Ping(context.Background()))
+}
+
+func TestFileStorageDeletePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewFileStorage(path, true)
+	s.SetGauge("old", 1)
+	s.SetGauge("kept", 2)
+	s.SetCounter("c", 3)
+
+	ok, err := s.Delete(models.Gauge, "old")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = s.ResetCounter("c")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	restored := NewFileStorage(path, false)
+	require.NoError(t, restored.Restore())
+	assert.Equal(t, map[string]float64{"kept": 2}, restored.GetAllGauges())
+	assert.Equal(t, map[string]int64{"c": 0}, restored.GetAllCounters())
+}
+
+func TestFileStorageSetPath(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.json")
+	newPath := filepath.Join(dir, "sub", "new.json")
+
+	stale := NewFileStorage(newPath, false)
+	stale.SetCounter("PollCount", 100)
+	stale.SetGauge("Stale", 1)
+	require.NoError(t, stale.Close())
+	info, err := os.Stat(newPath + ".wal")
+	require.NoError(t, err)
+	require.NotZero(t, info.Size())
+
+	s := NewFileStorage(oldPath, false)
+	s.SetCounter("PollCount", 5)
+
+	require.NoError(t, s.SetPath(newPath))
+	assert.Equal(t, newPath, s.Path())
+	_, err = os.Stat(oldPath + ".wal")
+	assert.True(t, os.IsNotExist(err), "old log is removed")
+
+	restored := NewFileStorage(newPath, false)
+	require.NoError(t, restored.Restore())
+	v, _ := restored.GetCounter("PollCount")
+	assert.Equal(t, int64(5), v)
+	assert.Empty(t, restored.GetAllGauges(), "stale log at the new path is not replayed")
+
+	blocker := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(blocker, nil, 0o644))
+	assert.Error(t, s.SetPath(filepath.Join(blocker, "metrics.json")))
+	assert.Equal(t, newPath, s.Path(), "path is kept when the new file cannot be written")
+
+	s.SetSyncWrite(true)
+	s.SetCounter("PollCount", 1)
+	restored = NewFileStorage(newPath, false)
+	require.NoError(t, restored.Restore())
+	v, _ = restored.GetCounter("PollCount")
+	assert.Equal(t, int64(6), v)
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		wantPath string
+		wantErr  bool
+	}{
+		{dsn: "mem://"},
+		{dsn: "file:///var/lib/metrics/db.json", wantPath: "/var/lib/metrics/db.json"},
+		{dsn: "file:metrics-db.json", wantPath: "metrics-db.json"},
+		{dsn: "file://data/metrics-db.json", wantPath: "data/metrics-db.json"},
+		{dsn: "file://", wantErr: true},
+		{dsn: "bolt://", wantErr: true},
+		{dsn: "redis://localhost", wantErr: true},
+		{dsn: "metrics-db.json", wantErr: true},
+		{dsn: "://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			s, err := Open(context.Background(), tt.dsn)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantPath == "" {
+				assert.IsType(t, &MemStorage{}, s)
+				return
+			}
+			require.IsType(t, &FileStorage{}, s)
+			assert.Equal(t, tt.wantPath, s.(*FileStorage).Path())
+		})
+	}
+}
@@ -0,0 +1,239 @@
+// Package storagetest содержит общий набор тестов, который должна
+// проходить каждая реализация storage.Storage.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/LemuriiL/MetricsAllerts/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run проверяет поведение хранилища. newStorage вызывается в каждом
+// подтесте и должна возвращать пустое хранилище.
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	t.Run("gauge is replaced", func(t *testing.T) {
+		s := newStorage(t)
+
+		_, ok := s.GetGauge("g")
+		assert.False(t, ok)
+
+		s.SetGauge("g", 1.5)
+		s.SetGauge("g", 2.5)
+
+		v, ok := s.GetGauge("g")
+		assert.True(t, ok)
+		assert.Equal(t, 2.5, v)
+	})
+
+	t.Run("counter is incremented", func(t *testing.T) {
+		s := newStorage(t)
+
+		_, ok := s.GetCounter("c")
+		assert.False(t, ok)
+
+		s.SetCounter("c", 3)
+		s.SetCounter("c", 4)
+
+		v, ok := s.GetCounter("c")
+		assert.True(t, ok)
+		assert.Equal(t, int64(7), v)
+	})
+
+	t.Run("get all", func(t *testing.T) {
+		s := newStorage(t)
+
+		s.SetGauge("g1", 1)
+		s.SetGauge("g2", 2)
+		s.SetCounter("c", 5)
+
+		assert.Equal(t, map[string]float64{"g1": 1, "g2": 2}, s.GetAllGauges())
+		assert.Equal(t, map[string]int64{"c": 5}, s.GetAllCounters())
+	})
+
+	t.Run("batch update", func(t *testing.T) {
+		s := newStorage(t)
+		s.SetCounter("c", 1)
+
+		val := 4.2
+		d1, d2 := int64(2), int64(3)
+		err := s.UpdateBatch([]models.Metrics{
+			{ID: "g", MType: models.Gauge, Value: &val},
+			{ID: "c", MType: models.Counter, Delta: &d1},
+			{ID: "c", MType: models.Counter, Delta: &d2},
+		})
+		require.NoError(t, err)
+
+		g, ok := s.GetGauge("g")
+		assert.True(t, ok)
+		assert.Equal(t, 4.2, g)
+		c, ok := s.GetCounter("c")
+		assert.True(t, ok)
+		assert.Equal(t, int64(6), c)
+	})
+
+	t.Run("invalid batch is not applied", func(t *testing.T) {
+		s := newStorage(t)
+
+		d := int64(2)
+		err := s.UpdateBatch([]models.Metrics{
+			{ID: "c", MType: models.Counter, Delta: &d},
+			{ID: "g", MType: models.Gauge},
+		})
+		assert.ErrorIs(t, err, storage.ErrInvalidMetric)
+		assert.Empty(t, s.GetAllGauges())
+		assert.Empty(t, s.GetAllCounters())
+	})
+
+	t.Run("histogram is merged", func(t *testing.T) {
+		s := newStorage(t)
+
+		h := models.NewHistogram([]float64{1, 2})
+		h.Observe(0.5)
+		s.SetHistogram("h", *h)
+		h.Observe(3)
+		err := s.UpdateBatch([]models.Metrics{{ID: "h", MType: models.Histogram, Histogram: h}})
+		require.NoError(t, err)
+
+		v, ok := s.GetHistogram("h")
+		require.True(t, ok)
+		assert.Equal(t, []uint64{2, 0, 1}, v.Counts)
+		assert.Equal(t, uint64(3), v.Count)
+		assert.Equal(t, 4.0, v.Sum)
+		assert.Equal(t, map[string]models.HistogramValue{"h": v}, s.GetAllHistograms())
+
+		other := models.NewHistogram([]float64{10})
+		other.Observe(5)
+		s.SetHistogram("h", *other)
+		v, ok = s.GetHistogram("h")
+		require.True(t, ok)
+		assert.Equal(t, *other, v, "histogram with new buckets replaces the old one")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s := newStorage(t)
+		s.SetGauge("m", 1)
+		s.SetCounter("m", 2)
+		h := models.NewHistogram([]float64{1})
+		s.SetHistogram("m", *h)
+		found := func(ok bool, err error) bool {
+			require.NoError(t, err)
+			return ok
+		}
+
+		assert.True(t, found(s.Delete(models.Gauge, "m")))
+		assert.False(t, found(s.Delete(models.Gauge, "m")))
+		_, ok := s.GetGauge("m")
+		assert.False(t, ok)
+		_, ok = s.GetCounter("m")
+		assert.True(t, ok, "metrics of other types are kept")
+
+		assert.True(t, found(s.Delete(models.Counter, "m")))
+		assert.True(t, found(s.Delete(models.Histogram, "m")))
+		assert.False(t, found(s.Delete("unknown", "m")))
+		assert.Empty(t, s.GetAllCounters())
+		assert.Empty(t, s.GetAllHistograms())
+	})
+
+	t.Run("reset counter", func(t *testing.T) {
+		s := newStorage(t)
+		s.SetCounter("c", 5)
+		found := func(ok bool, err error) bool {
+			require.NoError(t, err)
+			return ok
+		}
+
+		assert.True(t, found(s.ResetCounter("c")))
+		v, ok := s.GetCounter("c")
+		assert.True(t, ok)
+		assert.Equal(t, int64(0), v)
+
+		s.SetCounter("c", 2)
+		v, _ = s.GetCounter("c")
+		assert.Equal(t, int64(2), v)
+
+		assert.False(t, found(s.ResetCounter("missing")))
+		_, ok = s.GetCounter("missing")
+		assert.False(t, ok)
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		assert.NoError(t, newStorage(t).Ping(context.Background()))
+	})
+
+	t.Run("invalid histogram is rejected", func(t *testing.T) {
+		s := newStorage(t)
+
+		err := s.UpdateBatch([]models.Metrics{{
+			ID: "h", MType: models.Histogram,
+			Histogram: &models.HistogramValue{Bounds: []float64{1}, Counts: []uint64{1}},
+		}})
+		assert.ErrorIs(t, err, storage.ErrInvalidMetric)
+		assert.Empty(t, s.GetAllHistograms())
+	})
+
+	t.Run("concurrent access", func(t *testing.T) {
+		s := newStorage(t)
+
+		const (
+			workers = 8
+			rounds  = 50
+		)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			w := w
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				one := int64(1)
+				val := float64(w)
+				for i := 0; i < rounds; i++ {
+					s.SetCounter("c", 1)
+					err := s.UpdateBatch([]models.Metrics{
+						{ID: "c", MType: models.Counter, Delta: &one},
+						{ID: fmt.Sprintf("g%d", w), MType: models.Gauge, Value: &val},
+					})
+					assert.NoError(t, err)
+					s.GetAllGauges()
+					s.GetAllCounters()
+				}
+			}()
+		}
+		wg.Wait()
+
+		c, ok := s.GetCounter("c")
+		assert.True(t, ok)
+		assert.Equal(t, int64(2*workers*rounds), c, "no increments are lost")
+		assert.Len(t, s.GetAllGauges(), workers)
+	})
+
+	t.Run("get all returns copies", func(t *testing.T) {
+		s := newStorage(t)
+		s.SetGauge("g", 1)
+		s.SetCounter("c", 1)
+		h := models.NewHistogram([]float64{1})
+		h.Observe(0.5)
+		s.SetHistogram("h", *h)
+
+		gauges := s.GetAllGauges()
+		gauges["g"] = 100
+		gauges["new"] = 1
+		counters := s.GetAllCounters()
+		counters["c"] = 100
+		histograms := s.GetAllHistograms()
+		histograms["h"].Counts[0] = 100
+		one, _ := s.GetHistogram("h")
+		one.Counts[0] = 100
+
+		assert.Equal(t, map[string]float64{"g": 1}, s.GetAllGauges())
+		assert.Equal(t, map[string]int64{"c": 1}, s.GetAllCounters())
+		v, ok := s.GetHistogram("h")
+		require.True(t, ok)
+		assert.Equal(t, *h, v)
+	})
+}
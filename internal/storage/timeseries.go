@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Retention описывает, сколько хранить историю: сырые точки хранятся
+// Raw, затем усредняются по интервалам Resolution и хранятся до Total.
+type Retention struct {
+	Raw        time.Duration
+	Resolution time.Duration
+	Total      time.Duration
+}
+
+var DefaultRetention = Retention{
+	Raw:        time.Hour,
+	Resolution: time.Minute,
+	Total:      24 * time.Hour,
+}
+
+// SeriesReader реализуют хранилища, умеющие отдавать историю метрики.
+type SeriesReader interface {
+	Series(mtype, name string, from, to time.Time, step time.Duration) ([]Point, bool)
+}
+
+type bucket struct {
+	start time.Time
+	sum   float64
+	count int
+}
+
+type series struct {
+	raw    []Point
+	rollup []bucket
+}
+
+type seriesKey struct {
+	mtype string
+	name  string
+}
+
+// TimeSeriesStorage сохраняет историю значений поверх base. Последние
+// значения по-прежнему отдаются base, для счётчиков в историю пишется
+// накопленный итог после каждого обновления. История гистограмм не ведётся.
+type TimeSeriesStorage struct {
+	base      Storage
+	retention Retention
+	now       func() time.Time
+
+	mu     sync.RWMutex
+	series map[seriesKey]*series
+}
+
+func NewTimeSeriesStorage(base Storage, retention Retention) *TimeSeriesStorage {
+	return &TimeSeriesStorage{
+		base:      base,
+		retention: retention,
+		now:       time.Now,
+		series:    make(map[seriesKey]*series),
+	}
+}
+
+func (s *TimeSeriesStorage) SetGauge(name string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.base.SetGauge(name, value)
+	s.append(models.Gauge, name, value)
+}
+
+func (s *TimeSeriesStorage) GetGauge(name string) (float64, bool) {
+	return s.base.GetGauge(name)
+}
+
+func (s *TimeSeriesStorage) SetCounter(name string, value int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.base.SetCounter(name, value)
+	s.appendCounter(name)
+}
+
+func (s *TimeSeriesStorage) GetCounter(name string) (int64, bool) {
+	return s.base.GetCounter(name)
+}
+
+func (s *TimeSeriesStorage) GetAllGauges() map[string]float64 {
+	return s.base.GetAllGauges()
+}
+
+func (s *TimeSeriesStorage) GetAllCounters() map[string]int64 {
+	return s.base.GetAllCounters()
+}
+
+func (s *TimeSeriesStorage) SetHistogram(name string, value models.HistogramValue) {
+	s.base.SetHistogram(name, value)
+}
+
+func (s *TimeSeriesStorage) GetHistogram(name string) (models.HistogramValue, bool) {
+	return s.base.GetHistogram(name)
+}
+
+func (s *TimeSeriesStorage) GetAllHistograms() map[string]models.HistogramValue {
+	return s.base.GetAllHistograms()
+}
+
+func (s *TimeSeriesStorage) UpdateBatch(metrics []models.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.base.UpdateBatch(metrics); err != nil {
+		return err
+	}
+
+	for _, m := range models.Merge(metrics) {
+		switch m.MType {
+		case models.Gauge:
+			s.append(models.Gauge, m.Key(), *m.Value)
+		case models.Counter:
+			s.appendCounter(m.Key())
+		}
+	}
+	return nil
+}
+
+func (s *TimeSeriesStorage) Ping(ctx context.Context) error {
+	return s.base.Ping(ctx)
+}
+
+// Delete удаляет метрику вместе с её историей.
+func (s *TimeSeriesStorage) Delete(mtype, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ok, err := s.base.Delete(mtype, name)
+	if err != nil {
+		return false, err
+	}
+	delete(s.series, seriesKey{mtype, name})
+	return ok, nil
+}
+
+// ResetCounter обнуляет счётчик; история сохраняется, и в неё
+// записывается нулевое значение.
+func (s *TimeSeriesStorage) ResetCounter(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ok, err := s.base.ResetCounter(name)
+	if !ok || err != nil {
+		return false, err
+	}
+	s.appendCounter(name)
+	return true, nil
+}
+
+// Series возвращает точки из [from, to] по возрастанию времени. При
+// step > 0 точки усредняются по интервалам длины step. Точки старше
+// Total не возвращаются, даже если Sweep их ещё не удалил.
+func (s *TimeSeriesStorage) Series(mtype, name string, from, to time.Time, step time.Duration) ([]Point, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if cutoff := s.now().Add(-s.retention.Total); from.Before(cutoff) {
+		from = cutoff
+	}
+
+	sr, ok := s.series[seriesKey{mtype, name}]
+	if !ok {
+		return nil, false
+	}
+
+	points := make([]Point, 0, len(sr.rollup)+len(sr.raw))
+	for _, b := range sr.rollup {
+		if !b.start.Before(from) && !b.start.After(to) {
+			points = append(points, Point{Time: b.start, Value: b.sum / float64(b.count)})
+		}
+	}
+	for _, p := range sr.raw {
+		if !p.Time.Before(from) && !p.Time.After(to) {
+			points = append(points, p)
+		}
+	}
+
+	if step <= 0 {
+		return points, true
+	}
+	return downsample(points, step), true
+}
+
+// Sweep применяет сроки хранения ко всем сериям, в том числе к тем,
+// что давно не обновлялись, и удаляет опустевшие.
+func (s *TimeSeriesStorage) Sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for key, sr := range s.series {
+		s.compact(sr, now)
+		if len(sr.raw) == 0 && len(sr.rollup) == 0 {
+			delete(s.series, key)
+		}
+	}
+}
+
+// Run вызывает Sweep каждые interval до отмены ctx.
+func (s *TimeSeriesStorage) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep()
+		}
+	}
+}
+
+func (s *TimeSeriesStorage) appendCounter(name string) {
+	if total, ok := s.base.GetCounter(name); ok {
+		s.append(models.Counter, name, float64(total))
+	}
+}
+
+func (s *TimeSeriesStorage) append(mtype, name string, value float64) {
+	key := seriesKey{mtype, name}
+	sr, ok := s.series[key]
+	if !ok {
+		sr = &series{}
+		s.series[key] = sr
+	}
+
+	now := s.now()
+	sr.raw = append(sr.raw, Point{Time: now, Value: value})
+	s.compact(sr, now)
+}
+
+// compact переносит устаревшие сырые точки в агрегаты и удаляет
+// агрегаты старше Total.
+func (s *TimeSeriesStorage) compact(sr *series, now time.Time) {
+	rawCutoff := now.Add(-s.retention.Raw)
+	n := 0
+	for n < len(sr.raw) && sr.raw[n].Time.Before(rawCutoff) {
+		p := sr.raw[n]
+		start := p.Time.Truncate(s.retention.Resolution)
+		if last := len(sr.rollup) - 1; last >= 0 && sr.rollup[last].start.Equal(start) {
+			sr.rollup[last].sum += p.Value
+			sr.rollup[last].count++
+		} else {
+			sr.rollup = append(sr.rollup, bucket{start: start, sum: p.Value, count: 1})
+		}
+		n++
+	}
+	sr.raw = append(sr.raw[:0], sr.raw[n:]...)
+
+	totalCutoff := now.Add(-s.retention.Total)
+	n = 0
+	for n < len(sr.rollup) && sr.rollup[n].start.Before(totalCutoff) {
+		n++
+	}
+	sr.rollup = append(sr.rollup[:0], sr.rollup[n:]...)
+}
+
+func downsample(points []Point, step time.Duration) []Point {
+	var res []Point
+	var cur bucket
+	for _, p := range points {
+		start := p.Time.Truncate(step)
+		if cur.count > 0 && !cur.start.Equal(start) {
+			res = append(res, Point{Time: cur.start, Value: cur.sum / float64(cur.count)})
+			cur = bucket{}
+		}
+		if cur.count == 0 {
+			cur.start = start
+		}
+		cur.sum += p.Value
+		cur.count++
+	}
+	if cur.count > 0 {
+		res = append(res, Point{Time: cur.start, Value: cur.sum / float64(cur.count)})
+	}
+	return res
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSeries(start time.Time) (*TimeSeriesStorage, *time.Time) {
+	now := start
+	s := NewTimeSeriesStorage(NewMemStorage(), Retention{
+		Raw:        time.Hour,
+		Resolution: time.Minute,
+		Total:      24 * time.Hour,
+	})
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestTimeSeriesRawPoints(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s, now := newTestSeries(start)
+
+	s.SetGauge("g", 1)
+	*now = now.Add(10 * time.Second)
+	s.SetGauge("g", 2)
+
+	s.SetCounter("c", 3)
+	*now = now.Add(10 * time.Second)
+	d := int64(4)
+	require.NoError(t, s.UpdateBatch([]models.Metrics{{ID: "c", MType: models.Counter, Delta: &d}}))
+
+	points, ok := s.Series(models.Gauge, "g", start, *now, 0)
+	require.True(t, ok)
+	assert.Equal(t, []Point{
+		{Time: start, Value: 1},
+		{Time: start.Add(10 * time.Second), Value: 2},
+	}, points)
+
+	points, ok = s.Series(models.Counter, "c", start, *now, 0)
+	require.True(t, ok)
+	assert.Equal(t, []Point{
+		{Time: start.Add(10 * time.Second), Value: 3},
+		{Time: start.Add(20 * time.Second), Value: 7},
+	}, points)
+
+	v, ok := s.GetCounter("c")
+	assert.True(t, ok)
+	assert.Equal(t, int64(7), v)
+
+	_, ok = s.Series(models.Gauge, "missing", start, *now, 0)
+	assert.False(t, ok)
+}
+
+func TestTimeSeriesDeleteAndReset(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s, now := newTestSeries(start)
+
+	s.SetGauge("g", 1)
+	s.SetCounter("c", 5)
+	*now = now.Add(10 * time.Second)
+
+	ok, err := s.Delete(models.Gauge, "g")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, ok = s.Series(models.Gauge, "g", start, *now, 0)
+	assert.False(t, ok, "history is deleted with the metric")
+
+	ok, err = s.ResetCounter("c")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	points, ok := s.Series(models.Counter, "c", start, *now, 0)
+	require.True(t, ok)
+	assert.Equal(t, []Point{
+		{Time: start, Value: 5},
+		{Time: start.Add(10 * time.Second), Value: 0},
+	}, points)
+}
+
+func TestTimeSeriesDownsamplingAndRetention(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s, now := newTestSeries(start)
+
+	// Две точки в первой минуте, одна во второй.
+	s.SetGauge("g", 1)
+	*now = start.Add(30 * time.Second)
+	s.SetGauge("g", 3)
+	*now = start.Add(90 * time.Second)
+	s.SetGauge("g", 10)
+
+	// Через час с небольшим первые две минуты уходят в агрегаты.
+	*now = start.Add(time.Hour + 2*time.Minute)
+	s.SetGauge("g", 20)
+
+	points, ok := s.Series(models.Gauge, "g", start, *now, 0)
+	require.True(t, ok)
+	assert.Equal(t, []Point{
+		{Time: start, Value: 2},
+		{Time: start.Add(time.Minute), Value: 10},
+		{Time: start.Add(time.Hour + 2*time.Minute), Value: 20},
+	}, points)
+
+	points, _ = s.Series(models.Gauge, "g", start, *now, time.Hour)
+	assert.Equal(t, []Point{
+		{Time: start, Value: 6},
+		{Time: start.Add(time.Hour), Value: 20},
+	}, points)
+
+	// Через сутки агрегаты удаляются.
+	*now = start.Add(26 * time.Hour)
+	s.SetGauge("g", 30)
+
+	points, _ = s.Series(models.Gauge, "g", start, *now, 0)
+	assert.Equal(t, []Point{{Time: *now, Value: 30}}, points)
+}
+
+func TestTimeSeriesIdleSeriesExpires(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s, now := newTestSeries(start)
+
+	s.SetGauge("idle", 1)
+	*now = start.Add(2 * time.Hour)
+	s.SetGauge("active", 2)
+
+	// Без новых точек серия idle не сжималась, но за пределами Total
+	// её история не отдаётся.
+	*now = start.Add(25 * time.Hour)
+	points, ok := s.Series(models.Gauge, "idle", start, *now, 0)
+	assert.True(t, ok)
+	assert.Empty(t, points)
+
+	s.Sweep()
+	_, ok = s.Series(models.Gauge, "idle", start, *now, 0)
+	assert.False(t, ok, "expired series is removed")
+	points, ok = s.Series(models.Gauge, "active", start, *now, 0)
+	require.True(t, ok)
+	assert.Equal(t, []Point{{Time: start.Add(2 * time.Hour), Value: 2}}, points)
+	assert.Len(t, s.series, 1)
+
+	v, _ := s.GetGauge("idle")
+	assert.Equal(t, 1.0, v, "latest value is kept")
+}
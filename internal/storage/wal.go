@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+)
+
+// Журнал FileStorage — последовательность записей вида
+//
+//	длина (uint32 LE) | CRC32 IEEE данных (uint32 LE) | данные (JSON []walEntry)
+//
+// Запись хранит итоговое состояние изменённых метрик, а не дельты,
+// поэтому повторное применение журнала поверх снимка безопасно.
+
+const (
+	walHeaderSize = 8
+	// walMaxRecord ограничивает длину записи: большее значение в заголовке
+	// означает повреждённый журнал, а не огромную запись.
+	walMaxRecord = 64 << 20
+)
+
+var errTornRecord = errors.New("torn wal record")
+
+// walEntry — состояние одной метрики после изменения. Deleted отмечает
+// удалённую метрику; значение тогда не задано.
+type walEntry struct {
+	models.Metrics
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// encodeWALRecord кодирует записи одной операции в кадр журнала.
+func encodeWALRecord(entries []walEntry) ([]byte, error) {
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[walHeaderSize:], payload)
+	return buf, nil
+}
+
+// decodeWALRecord читает запись из начала data и возвращает её длину
+// вместе с заголовком. Неполная или повреждённая запись даёт errTornRecord.
+func decodeWALRecord(data []byte) ([]walEntry, int, error) {
+	if len(data) < walHeaderSize {
+		return nil, 0, errTornRecord
+	}
+	n := binary.LittleEndian.Uint32(data[0:4])
+	if n > walMaxRecord || int(n) > len(data)-walHeaderSize {
+		return nil, 0, errTornRecord
+	}
+	payload := data[walHeaderSize : walHeaderSize+int(n)]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[4:8]) {
+		return nil, 0, errTornRecord
+	}
+	var entries []walEntry
+	if err := json.Unmarshal(payload, &entries); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errTornRecord, err)
+	}
+	return entries, walHeaderSize + int(n), nil
+}
+
+// replayWAL применяет к base записи журнала по порядку. Чтение
+// останавливается на первой неполной или повреждённой записи: всё
+// после неё не считается записанным. Возвращается смещение конца
+// последней целой записи.
+func replayWAL(base *MemStorage, data []byte) (int, error) {
+	off := 0
+	for off < len(data) {
+		entries, n, err := decodeWALRecord(data[off:])
+		if err != nil {
+			return off, err
+		}
+		for _, e := range entries {
+			applyWALEntry(base, e)
+		}
+		off += n
+	}
+	return off, nil
+}
+
+func applyWALEntry(base *MemStorage, e walEntry) {
+	key := e.Key()
+	base.Delete(e.MType, key)
+	if e.Deleted {
+		return
+	}
+	switch e.MType {
+	case models.Gauge:
+		if e.Value != nil {
+			base.SetGauge(key, *e.Value)
+		}
+	case models.Counter:
+		if e.Delta != nil {
+			base.SetCounter(key, *e.Delta)
+		}
+	case models.Histogram:
+		if e.Histogram != nil && e.Histogram.Validate() == nil {
+			base.SetHistogram(key, *e.Histogram)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LemuriiL/MetricsAllerts/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fileState struct {
+	gauges     map[string]float64
+	counters   map[string]int64
+	histograms map[string]models.HistogramValue
+}
+
+func stateOf(s Storage) fileState {
+	return fileState{s.GetAllGauges(), s.GetAllCounters(), s.GetAllHistograms()}
+}
+
+func restored(t *testing.T, path string) fileState {
+	t.Helper()
+	s := NewFileStorage(path, false)
+	require.NoError(t, s.Restore())
+	return stateOf(s)
+}
+
+func walOps() []func(s *FileStorage) {
+	h := models.NewHistogram([]float64{1, 10})
+	h.Observe(5)
+	host := models.Key("Alloc", map[string]string{"host": "a"})
+	return []func(s *FileStorage){
+		func(s *FileStorage) { s.SetGauge("Alloc", 1) },
+		func(s *FileStorage) { s.SetCounter("PollCount", 2) },
+		func(s *FileStorage) { s.SetCounter("PollCount", 3) },
+		func(s *FileStorage) { s.SetGauge(host, 4.5) },
+		func(s *FileStorage) { s.SetHistogram("GCPause", *h) },
+		func(s *FileStorage) { s.SetHistogram("GCPause", *h) },
+		func(s *FileStorage) { s.Delete(models.Gauge, "Alloc") },
+		func(s *FileStorage) { s.ResetCounter("PollCount") },
+		func(s *FileStorage) {
+			v, d := 7.0, int64(8)
+			_ = s.UpdateBatch([]models.Metrics{
+				{ID: "Alloc", MType: models.Gauge, Value: &v},
+				{ID: "PollCount", MType: models.Counter, Delta: &d},
+				{ID: "PollCount", MType: models.Counter, Delta: &d},
+			})
+		},
+	}
+}
+
+func TestFileStorageWALReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewFileStorage(path, true)
+
+	ops := walOps()
+	ops[0](s)
+	snapshot, err := os.ReadFile(path)
+	require.NoError(t, err)
+	for _, op := range ops[1:] {
+		op(s)
+	}
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, snapshot, after, "writes go to the log, not to the snapshot")
+	assert.Equal(t, stateOf(s), restored(t, path))
+	assert.Equal(t, int64(16), restored(t, path).counters["PollCount"])
+}
+
+func TestFileStorageTornWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewFileStorage(path, true)
+
+	// Для каждой целой записи журнала запоминаем его длину и состояние.
+	var (
+		sizes  []int64
+		states []fileState
+	)
+	for _, op := range walOps() {
+		op(s)
+		info, err := os.Stat(walPath(path))
+		require.NoError(t, err)
+		sizes = append(sizes, info.Size())
+		states = append(states, stateOf(s))
+	}
+	full, err := os.ReadFile(walPath(path))
+	require.NoError(t, err)
+	require.Equal(t, sizes[len(sizes)-1], int64(len(full)))
+
+	for cut := int64(0); cut <= int64(len(full)); cut++ {
+		require.NoError(t, os.WriteFile(walPath(path), full[:cut], 0o644))
+
+		// Ожидается состояние после последней записи, целиком попавшей в cut.
+		want := states[0]
+		for i, size := range sizes {
+			if size <= cut {
+				want = states[i]
+			}
+		}
+		require.Equal(t, want, restored(t, path), "log truncated to %d bytes", cut)
+	}
+
+	t.Run("corrupted record", func(t *testing.T) {
+		data := append([]byte(nil), full...)
+		data[sizes[len(sizes)-2]+walHeaderSize] ^= 0xff
+		require.NoError(t, os.WriteFile(walPath(path), data, 0o644))
+		assert.Equal(t, states[len(states)-2], restored(t, path))
+	})
+
+	t.Run("appends after torn tail", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(walPath(path), full[:len(full)-3], 0o644))
+		s := NewFileStorage(path, true)
+		require.NoError(t, s.Restore())
+		s.SetGauge("after", 1)
+
+		want := stateOf(s)
+		assert.Equal(t, want, restored(t, path))
+	})
+}
+
+func TestFileStorageCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewFileStorage(path, false)
+	s.compactSize = 512
+
+	for i := 0; i < 200; i++ {
+		s.SetCounter("PollCount", 1)
+		s.SetGauge("Alloc", float64(i))
+	}
+	require.NoError(t, s.Sync())
+
+	info, err := os.Stat(walPath(path))
+	require.NoError(t, err)
+	assert.Less(t, info.Size(), int64(512))
+	assert.Equal(t, stateOf(s), restored(t, path))
+
+	// Сбой между записью снимка и очисткой журнала: старый журнал
+	// применяется поверх нового снимка и не меняет результат.
+	stale, err := os.ReadFile(walPath(path))
+	require.NoError(t, err)
+	require.NotEmpty(t, stale)
+	require.NoError(t, s.Save())
+	require.NoError(t, os.WriteFile(walPath(path), stale, 0o644))
+	assert.Equal(t, stateOf(s), restored(t, path))
+	assert.Equal(t, int64(200), restored(t, path).counters["PollCount"])
+}
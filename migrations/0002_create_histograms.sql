@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS histograms (
+    id   TEXT PRIMARY KEY,
+    data JSONB NOT NULL
+);
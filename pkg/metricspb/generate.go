@@ -0,0 +1,5 @@
+// Package metricspb содержит сгенерированный по api/metrics.proto
+// контракт gRPC-сервиса приёма метрик.
+package metricspb
+
+//go:generate protoc -I ../../api --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ../../api/metrics.proto